@@ -1,6 +1,11 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -9,7 +14,7 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"net/url"
+	"os"
 	"path/filepath"
 	"time"
 )
@@ -62,23 +67,25 @@ func newFormData() FormData {
 	}
 }
 
-type Users = map[string]User
-
-type User struct {
-	id     string
-	client indieAuth.Config
+func newClient(ctx context.Context, id string) (indieAuth.Config, error) {
+	return indieAuth.NewContext(ctx, id, ClientOptions...)
 }
 
-func newUser(id string) (User, error) {
-	indieAuthClient, err := indieAuth.New(id)
-	if err != nil {
-		return User{}, err
+// newSessionID returns an unguessable id for a signed in user, kept in their cookie to find their client again.
+// Each login gets its own, so signing in to the same site from two browsers does not share a refresh token.
+func newSessionID() (string, error) {
+	data := make([]byte, 16)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
 	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
 
-	return User{
-		id,
-		indieAuthClient,
-	}, nil
+// saveClient stores the client between requests. Failures are logged, the current request can still complete.
+func saveClient(id string, client indieAuth.Config) {
+	if err := Sessions.Save(id, client); err != nil {
+		log.Printf("\n\n--Encountered an error saving %v: %v", id, err.Error())
+	}
 }
 
 type Progress struct {
@@ -97,6 +104,7 @@ type Data struct {
 	Progress      Progress
 	RedirectURL   string
 	Authenticated bool
+	Profile       indieAuth.Profile
 }
 
 func newData() Data {
@@ -107,9 +115,34 @@ func newData() Data {
 	}
 }
 
-var ClientUsers = make(Users)
+// Logins holds the indieAuth client of each login in progress, keyed by its state, until the state expires.
+var Logins indieAuth.Store = indieAuth.NewMemoryStore(indieAuth.DefaultStateExpiry)
+
+// Sessions holds the indieAuth client of each signed in user, keyed by their session id, for a day.
+var Sessions indieAuth.Store = indieAuth.NewMemoryStore(24 * time.Hour)
+
+// ClientOptions configure every indieAuth client created by the website.
+var ClientOptions []indieAuth.Option
 
 func main() {
+	conf, err := indieAuth.LoadConfig("./config.yaml")
+	if err != nil {
+		log.Fatalf("Unable to load ./config.yaml: %v", err)
+	}
+	ClientOptions = append(ClientOptions, indieAuth.WithConf(conf))
+
+	// Keep logins across restarts when a directory is provided.
+	if dir := os.Getenv("INDIEAUTH_STORE_DIR"); dir != "" {
+		Logins, err = indieAuth.NewFileStore(filepath.Join(dir, "logins"), indieAuth.DefaultStateExpiry)
+		if err != nil {
+			log.Fatalf("Unable to create the store in %v: %v", dir, err)
+		}
+		Sessions, err = indieAuth.NewFileStore(filepath.Join(dir, "sessions"), 24*time.Hour)
+		if err != nil {
+			log.Fatalf("Unable to create the store in %v: %v", dir, err)
+		}
+	}
+	ClientOptions = append(ClientOptions, indieAuth.WithStore(Logins))
 
 	e := echo.New()
 	e.Use(middleware.Logger())
@@ -142,60 +175,82 @@ func auth(c echo.Context) error {
 	data := newData()
 	data.Progress.Step = "authorization-request"
 
-	indieAuthClientUser, err := newUser(website)
+	indieAuthClient, err := newClient(c.Request().Context(), website)
 	if err != nil {
 		formData.Errors["url"] = fmt.Sprintf("Error when trying to parse the url: %v", err)
 		return c.Render(http.StatusUnprocessableEntity, "login-form", formData)
 	}
-	indieAuthClient := indieAuthClientUser.client
-
-	ClientUsers[indieAuthClient.Identifier.ProfileURL] = indieAuthClientUser
 
 	formData.Values["url"] = website
+
+	var scopes []indieAuth.Scope
+	if params, err := c.FormParams(); err == nil {
+		for _, scope := range params["scope"] {
+			scopes = append(scopes, indieAuth.Scope(scope))
+		}
+	}
+
+	// The login is saved to Logins keyed by its state, which the authorization server always returns, unlike `me`.
+	authorization, err := indieAuthClient.NewAuthorizationRequest(scopes...)
+	if err != nil {
+		formData.Errors["url"] = fmt.Sprintf("Error when building the authorization request: %v", err)
+		return c.Render(http.StatusUnprocessableEntity, "login-form", formData)
+	}
+
 	formData.Values["authorization_endpoint"] = indieAuthClient.Endpoint.AuthURL
 	formData.Values["token_endpoint"] = indieAuthClient.Endpoint.TokenURL
 
 	c.Render(http.StatusOK, "login-form", formData)
 	c.Render(http.StatusOK, "auth-form", formData)
-	c.Render(http.StatusOK, "url", indieAuthClient.GetAuthorizationRequestURL())
+	c.Render(http.StatusOK, "url", authorization.URL)
 
 	return c.Render(http.StatusOK, "progress", data.Progress)
 }
 
 func redirect(c echo.Context) error {
-	code := c.QueryParam("code")
-	state := c.QueryParam("state")
-	me := c.QueryParam("me")
-	// Apparently this is optional, or indieauth.com doesn't implement it.
-	issuer := c.QueryParam("iss")
 	data := newData()
 	data.Progress.Step = "redeeming-authorization-code"
 	formData := newFormData()
-	id, err := url.QueryUnescape(me)
+	data.Form = formData
 
-	formData.Values["code"] = code
-	formData.Values["state"] = state
-	formData.Values["me"] = me
-	formData.Values["iss"] = issuer
-	formData.Values["url"] = id
+	callback, err := indieAuth.ParseCallback(c.QueryParams())
 
+	var oauthErr *indieAuth.OAuthError
+	if errors.As(err, &oauthErr) {
+		// Only trust an error for a login in progress, which is then over.
+		if _, takeErr := indieAuth.TakeAuthorizationRequest(Logins, callback.State); takeErr != nil {
+			formData.Errors["url"] = fmt.Sprintf("Error when looking up the login: %v", errorMessage(takeErr))
+			return c.Render(http.StatusUnprocessableEntity, "index", data)
+		}
+		formData.Errors["url"] = fmt.Sprintf("Authorization was not granted: %v", errorMessage(err))
+		return c.Render(http.StatusOK, "index", data)
+	}
 	if err != nil {
-		formData.Errors["url"] = fmt.Sprintf("Error when unescaping the me value received: %v", err)
-		return c.Render(http.StatusUnprocessableEntity, "code-exchange-form", formData)
+		formData.Errors["url"] = fmt.Sprintf("Error when reading the response from the authorization server: %v", err)
+		return c.Render(http.StatusUnprocessableEntity, "index", data)
 	}
 
-	u, ok := ClientUsers[id]
+	// Apparently iss is optional, or indieauth.com doesn't implement it.
+	formData.Values["code"] = callback.Code
+	formData.Values["state"] = callback.State
+	formData.Values["me"] = callback.Me
+	formData.Values["iss"] = callback.Issuer
+	formData.Values["url"] = callback.Me
 
-	if !ok {
-		formData.Errors["url"] = fmt.Sprintf("No user for id: %v was found registered", id)
-		return c.Render(http.StatusUnprocessableEntity, "code-exchange-form", formData)
-	}
+	client, err := Logins.Load(callback.State)
 
-	data.RedirectURL = u.client.RedirectURL
-	formData.Values["authorization_endpoint"] = u.client.Endpoint.AuthURL
-	formData.Values["token_endpoint"] = u.client.Endpoint.TokenURL
+	if err != nil {
+		formData.Errors["url"] = "No login in progress was found for the state returned, please start again"
+		return c.Render(http.StatusUnprocessableEntity, "index", data)
+	}
 
-	data.Form = formData
+	if callback.Me == "" {
+		formData.Values["me"] = client.Identifier.ProfileURL
+		formData.Values["url"] = client.Identifier.ProfileURL
+	}
+	data.RedirectURL = client.RedirectURL
+	formData.Values["authorization_endpoint"] = client.Endpoint.AuthURL
+	formData.Values["token_endpoint"] = client.Endpoint.TokenURL
 
 	return c.Render(http.StatusOK, "index", data)
 }
@@ -212,59 +267,149 @@ func tokenExchange(c echo.Context) error {
 	formData.Values["me"] = me
 	formData.Values["iss"] = issuer
 
-	id, err := url.QueryUnescape(me)
+	formData.Values["url"] = me
+
+	// Taking the login ensures its code is only ever redeemed once, even if the form is submitted again.
+	client, err := indieAuth.TakeAuthorizationRequest(Logins, state)
+
 	if err != nil {
-		formData.Errors["url"] = fmt.Sprintf("Error when unescaping the me value received: %v", err)
+		formData.Errors["url"] = fmt.Sprintf("Error when looking up the login: %v", errorMessage(err))
 		return c.Render(http.StatusUnprocessableEntity, "code-exchange-form", formData)
 	}
 
-	formData.Values["url"] = id
-	u, ok := ClientUsers[id]
-
-	if !ok {
-		formData.Errors["url"] = fmt.Sprintf("No user for id: %v was found registered", id)
+	token, err := client.TokenExchangeContext(c.Request().Context(), state, code, issuer)
+	if err != nil {
+		formData.Errors["url"] = fmt.Sprintf("Error when attempting to exchange the token: %v", errorMessage(err))
 		return c.Render(http.StatusUnprocessableEntity, "code-exchange-form", formData)
 	}
 
-	token, err := u.client.TokenExchange(state, code, issuer)
+	// The login is complete, from here on the client is found by the session id in the user's cookie.
+	id, err := newSessionID()
 	if err != nil {
-		formData.Errors["url"] = fmt.Sprintf("Error when attempting to exchange the token: %v", err.Error())
+		formData.Errors["url"] = fmt.Sprintf("Error when starting the session: %v", err)
 		return c.Render(http.StatusUnprocessableEntity, "code-exchange-form", formData)
 	}
+	formData.Values["url"] = client.Identifier.ProfileURL
+	saveClient(id, client)
 
 	data.Progress.Step = "refresh"
 
-	// Write a cookie
-	cookie := new(http.Cookie)
-	cookie.Name = "indieAuthClient"
-	cookie.Value = token
-	cookie.HttpOnly = true
-	cookie.Expires = time.Now().Add(24 * time.Hour)
-	c.SetCookie(cookie)
+	setSessionCookies(c, id, token)
 
 	formData.Values["token"] = token
-	formData.Values["refresh"] = u.client.Token.RefreshToken
-	formData.Values["expires_in"] = "1"
+	formData.Values["refresh"] = client.Token.RefreshToken
+	formData.Values["expires_in"] = expiresIn(client.Token)
 
 	c.Render(http.StatusOK, "progress", data.Progress)
 	c.Render(http.StatusOK, "refresh-form", formData)
 	return c.Render(http.StatusOK, "code-exchange-form", formData)
 }
 
+// errorMessage describes err for the user, preferring the authorization server's own description.
+func errorMessage(err error) string {
+	var oauthErr *indieAuth.OAuthError
+	switch {
+	case errors.As(err, &oauthErr) && oauthErr.Description != "":
+		return oauthErr.Description
+	case errors.As(err, &oauthErr) && oauthErr.Code == "access_denied":
+		return "the request was denied"
+	case errors.As(err, &oauthErr):
+		return fmt.Sprintf("the authorization server responded with %v", oauthErr.Code)
+	case errors.Is(err, indieAuth.ErrStateMismatch), errors.Is(err, indieAuth.ErrStateUnknown):
+		return "the state returned does not match this login, please start again"
+	case errors.Is(err, indieAuth.ErrStateExpired):
+		return "the login took too long, please start again"
+	case errors.Is(err, indieAuth.ErrStateReused):
+		return "this login has already been completed, please start again"
+	case errors.Is(err, indieAuth.ErrIssuerMismatch):
+		return "the response came from a different authorization server than the one discovered"
+	case errors.Is(err, indieAuth.ErrMeMismatch):
+		return fmt.Sprintf("the authorization server cannot vouch for this profile URL (%v)", err)
+	}
+
+	return err.Error()
+}
+
+// setSessionCookies remembers the session of the user who logged in and the access token they were issued.
+func setSessionCookies(c echo.Context, id string, token string) {
+	cookies := map[string]string{
+		"indieAuthClient":  token,
+		"indieAuthSession": id,
+	}
+
+	for name, value := range cookies {
+		cookie := new(http.Cookie)
+		cookie.Name = name
+		cookie.Value = value
+		cookie.HttpOnly = true
+		cookie.Expires = time.Now().Add(24 * time.Hour)
+		c.SetCookie(cookie)
+	}
+}
+
+func expiresIn(token indieAuth.Token) string {
+	if token.Expiry.IsZero() {
+		return "Never"
+	}
+	if token.IsExpired() {
+		return "Expired"
+	}
+	return time.Until(token.Expiry).Round(time.Second).String()
+}
+
 func refresh(c echo.Context) error {
-	data := newData()
-	return c.Render(200, "refresh-form", data)
+	formData := newFormData()
+
+	// The user is identified by their session cookies, never by the form, so only they can refresh their token.
+	id, client, err := session(c, true)
+
+	if err != nil {
+		log.Printf("\n\n--Encountered an error %v", err.Error())
+		formData.Errors["url"] = "You must be signed in to refresh your token"
+		return c.Render(http.StatusUnprocessableEntity, "refresh-form", formData)
+	}
+
+	token, err := client.RefreshContext(c.Request().Context())
+	if err != nil {
+		formData.Errors["url"] = fmt.Sprintf("Error when attempting to refresh the token: %v", errorMessage(err))
+		return c.Render(http.StatusUnprocessableEntity, "refresh-form", formData)
+	}
+	saveClient(id, client)
+
+	setSessionCookies(c, id, token)
+
+	formData.Values["token"] = token
+	formData.Values["refresh"] = client.Token.RefreshToken
+	formData.Values["expires_in"] = expiresIn(client.Token)
+
+	return c.Render(http.StatusOK, "refresh-form", formData)
 }
 
 func reset(c echo.Context) error {
-	ClientUsers = make(Users)
-	c.SetCookie(&http.Cookie{
-		Name:     "indieAuthClient",
-		Value:    "",
-		Expires:  time.Unix(0, 0),
-		MaxAge:   -1,
-		HttpOnly: true,
-	})
+	// Revoke the token at the authorization server, rather than only forgetting it.
+	if id, err := c.Cookie("indieAuthSession"); err == nil {
+		if token, err := c.Cookie("indieAuthClient"); err == nil {
+			if client, err := Sessions.Load(id.Value); err == nil {
+				if err := client.RevokeContext(c.Request().Context(), token.Value); err != nil {
+					log.Printf("\n\n--Encountered an error %v", err.Error())
+				}
+			}
+		}
+
+		if err := Sessions.Delete(id.Value); err != nil {
+			log.Printf("\n\n--Encountered an error %v", err.Error())
+		}
+	}
+
+	for _, name := range []string{"indieAuthClient", "indieAuthSession"} {
+		c.SetCookie(&http.Cookie{
+			Name:     name,
+			Value:    "",
+			Expires:  time.Unix(0, 0),
+			MaxAge:   -1,
+			HttpOnly: true,
+		})
+	}
 	data := newData()
 
 	return c.Render(http.StatusOK, "index", data)
@@ -272,16 +417,71 @@ func reset(c echo.Context) error {
 
 func secrets(c echo.Context) error {
 	data := newData()
-	cookie, err := c.Cookie("indieAuthClient")
+
+	id, client, err := session(c, false)
 	if err != nil {
 		log.Printf("\n\n--Encountered an error %v", err.Error())
 		return c.Render(http.StatusForbidden, "secrets", data)
 	}
-	token := cookie.Value
-	log.Printf("User token is %v", token)
 
 	data.Authenticated = true
 
+	if client.Identifier.Profile == (indieAuth.Profile{}) && client.Endpoint.UserinfoURL != "" {
+		if _, err := client.UserInfoContext(c.Request().Context()); err != nil {
+			log.Printf("\n\n--Encountered an error %v", err.Error())
+		}
+		saveClient(id, client)
+	}
+	data.Profile = client.Identifier.Profile
+
 	// @TODO Clean the "data" structure up to be more sane
 	return c.Render(http.StatusOK, "secrets", data)
 }
+
+// session returns the session id and client of the user signed in with the request's cookies. The access token in the
+// cookie is checked with the authorization server when it can be introspected, otherwise against the token issued.
+// When allowExpired is set, a token that has simply expired is accepted if it is the one last issued to the user,
+// so it can be refreshed.
+func session(c echo.Context, allowExpired bool) (string, indieAuth.Config, error) {
+	tokenCookie, err := c.Cookie("indieAuthClient")
+	if err != nil {
+		return "", indieAuth.Config{}, err
+	}
+
+	sessionCookie, err := c.Cookie("indieAuthSession")
+	if err != nil {
+		return "", indieAuth.Config{}, err
+	}
+	id := sessionCookie.Value
+
+	client, err := Sessions.Load(id)
+	if err != nil {
+		return "", indieAuth.Config{}, errors.New("no session was found for the cookie")
+	}
+
+	token := tokenCookie.Value
+	issued := subtle.ConstantTimeCompare([]byte(token), []byte(client.Token.AccessToken)) == 1
+	if allowExpired && client.Token.IsExpired() && issued {
+		return id, client, nil
+	}
+
+	// Introspection endpoints published in metadata require authorization the website doesn't have, so the token is
+	// checked against the one issued to the user instead.
+	if !client.CanIntrospect() {
+		if !issued || client.Token.IsExpired() {
+			return "", indieAuth.Config{}, fmt.Errorf("the token for %v is not the one issued", client.Identifier.ProfileURL)
+		}
+		return id, client, nil
+	}
+
+	introspection, err := client.IntrospectContext(c.Request().Context(), token)
+	if err != nil {
+		return "", indieAuth.Config{}, err
+	}
+
+	if !introspection.ActiveFor(client.Identifier.ProfileURL) {
+		return "", indieAuth.Config{}, fmt.Errorf("the token for %v is not active", client.Identifier.ProfileURL)
+	}
+
+	return id, client, nil
+}
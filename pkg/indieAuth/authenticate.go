@@ -0,0 +1,69 @@
+package indieAuth
+
+import (
+	"context"
+)
+
+// GetAuthenticationRequestURL builds a request that only asks the user to prove who they are. No scope is
+// requested, so no access token will be issued; redeem the code with Authenticate. The request is sent with c.State, or a new
+// state once the last has been consumed, see NewAuthenticationRequest to start a sign-in with its own.
+// https://indieauth.spec.indieweb.org/#authorization-request
+func (c *Config) GetAuthenticationRequestURL() (string, error) {
+	if err := c.ensureState(); err != nil {
+		return "", err
+	}
+
+	verifier, err := generateCodeVerifier(c.randomReader())
+
+	if err != nil {
+		return "", err
+	}
+
+	c.Verifier = verifier
+	params := getHandshakeParams(*c)
+	params.Del("scope")
+
+	return c.authRequestURL(params)
+}
+
+// NewAuthenticationRequest is like NewAuthorizationRequest, but starts a sign-in that requests no scope, as with
+// GetAuthenticationRequestURL. Redeem the code with Authenticate once the login is taken back by its state.
+func (c *Config) NewAuthenticationRequest() (AuthorizationRequest, error) {
+	return c.newRequest((*Config).GetAuthenticationRequestURL)
+}
+
+// Authenticate redeems an authorization code at the authorization endpoint, returning the verified identity of
+// the user without issuing an access token.
+// https://indieauth.spec.indieweb.org/#profile-url-response
+func (c *Config) Authenticate(state string, code string, iss string) (Identifier, error) {
+	return c.AuthenticateContext(context.Background(), state, code, iss)
+}
+
+// AuthenticateContext is like Authenticate, but every request made to the authorization server is bound to ctx.
+func (c *Config) AuthenticateContext(ctx context.Context, state string, code string, iss string) (Identifier, error) {
+	err := c.verifyCallback(state, iss)
+
+	if err != nil {
+		return Identifier{}, err
+	}
+
+	params := getTokenExchangeParams(*c, code)
+
+	response, err := getTokenURLResponse(ctx, c.httpClient(), c.Endpoint.AuthURL, params)
+
+	if err != nil {
+		return Identifier{}, err
+	}
+
+	err = c.verifyMe(ctx, response.Me)
+
+	if err != nil {
+		return Identifier{}, err
+	}
+
+	if response.Profile != nil {
+		c.Identifier.Profile = *response.Profile
+	}
+
+	return c.Identifier, nil
+}
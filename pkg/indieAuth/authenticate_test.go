@@ -0,0 +1,120 @@
+package indieAuth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestGetAuthenticationRequestURL(t *testing.T) {
+	c := Config{
+		ClientID:    "http://localhost:9002/",
+		Endpoint:    Endpoint{AuthURL: "https://example.com/auth"},
+		Identifier:  Identifier{ProfileURL: "https://example.com/"},
+		RedirectURL: "http://localhost:9002/redirect",
+		Scopes:      Scopes{ScopeProfile, ScopeEmail},
+		State:       "state",
+	}
+
+	authURL, err := c.GetAuthenticationRequestURL()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	query := u.Query()
+	if query.Has("scope") {
+		t.Errorf("Expected no scope in an authentication request, got %v", query.Get("scope"))
+	}
+	if query.Get("response_type") != "code" || query.Get("state") != "state" || query.Get("code_challenge") == "" {
+		t.Errorf("Unexpected authentication request %v", query)
+	}
+}
+
+func TestNewAuthenticationRequest(t *testing.T) {
+	s := NewMemoryStore(time.Hour)
+	c := Config{
+		ClientID:    "http://localhost:9002/",
+		Endpoint:    Endpoint{AuthURL: "https://example.com/auth"},
+		Identifier:  Identifier{ProfileURL: "https://example.com/"},
+		RedirectURL: "http://localhost:9002/redirect",
+		Scopes:      Scopes{ScopeProfile, ScopeEmail},
+		State:       "state",
+		store:       s,
+	}
+
+	first, err := c.NewAuthenticationRequest()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	second, err := c.NewAuthenticationRequest()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if first.State == "state" || first.State == second.State || first.Config.Verifier == second.Config.Verifier {
+		t.Errorf("Expected a fresh state and verifier per request, got %+v and %+v", first, second)
+	}
+	if first.Config.StateIssued.IsZero() {
+		t.Errorf("Expected the state issue time to be set")
+	}
+
+	u, err := url.Parse(first.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if query := u.Query(); query.Has("scope") || query.Get("state") != first.State {
+		t.Errorf("Unexpected authentication request %v", query)
+	}
+
+	if got, err := c.TakeAuthorizationRequest(first.State); err != nil || got.Verifier != first.Config.Verifier {
+		t.Errorf("TakeAuthorizationRequest() = %+v, %v, want the saved sign-in", got, err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.URL.Path != "/auth" || r.PostForm.Get("code") != "code" || r.PostForm.Get("code_verifier") != "verifier" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"me": "https://example.com/", "profile": {"name": "Example User", "url": "https://example.com/"}}`))
+	}))
+	defer ts.Close()
+
+	c := Config{
+		ClientID:    "http://localhost:9002/",
+		Endpoint:    Endpoint{AuthURL: ts.URL + "/auth"},
+		Identifier:  Identifier{ProfileURL: "https://example.com/"},
+		RedirectURL: "http://localhost:9002/redirect",
+		State:       "state",
+		Verifier:    "verifier",
+		client:      ts.Client(),
+	}
+
+	if _, err := c.Authenticate("other_state", "code", ""); err == nil {
+		t.Errorf("Expected an error for a mismatched state")
+	}
+
+	id, err := c.Authenticate("state", "code", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if id.ProfileURL != "https://example.com/" {
+		t.Errorf("Expected me 'https://example.com/', got '%v'", id.ProfileURL)
+	}
+	if id.Profile.Name != "Example User" {
+		t.Errorf("Expected profile name 'Example User', got '%v'", id.Profile.Name)
+	}
+	if c.Token.AccessToken != "" {
+		t.Errorf("Expected no access token, got '%v'", c.Token.AccessToken)
+	}
+}
@@ -0,0 +1,98 @@
+package indieAuth
+
+import "errors"
+
+// AuthorizationRequest is a single login in progress. Each has its own state and code verifier, so any number
+// can be pending for the same profile URL, for instance from two browsers, without overwriting each other.
+type AuthorizationRequest struct {
+	// URL is where to send the user.
+	URL string
+	// State is unguessable and returned untouched on the redirect URL, so it is the key to save Config under
+	// until the user comes back. Unlike `me`, the authorization server must always include it.
+	State string
+	// Config holds the state and verifier needed by TokenExchange to redeem the code.
+	Config Config
+}
+
+// NewAuthorizationRequest starts a login with a fresh state and code verifier, leaving c untouched so it can
+// start others. The scopes requested default to c.Scopes, as with GetAuthorizationRequestURL. When a Store was
+// provided with WithStore, the login is saved to it under its state.
+func (c *Config) NewAuthorizationRequest(scopes ...Scope) (AuthorizationRequest, error) {
+	return c.newRequest(func(pending *Config) (string, error) {
+		return pending.GetAuthorizationRequestURL(scopes...)
+	})
+}
+
+// newRequest starts a login from a copy of c with a fresh state, built by requestURL, which also generates the
+// code verifier.
+func (c *Config) newRequest(requestURL func(*Config) (string, error)) (AuthorizationRequest, error) {
+	state, err := c.newState()
+	if err != nil {
+		return AuthorizationRequest{}, err
+	}
+
+	pending := *c
+	pending.State = state
+	pending.StateIssued = timeNow()
+	pending.Token = Token{}
+
+	u, err := requestURL(&pending)
+	if err != nil {
+		return AuthorizationRequest{}, err
+	}
+
+	if c.store != nil {
+		if err := c.store.Save(state, pending); err != nil {
+			return AuthorizationRequest{}, err
+		}
+	}
+
+	return AuthorizationRequest{
+		URL:    u,
+		State:  pending.State,
+		Config: pending,
+	}, nil
+}
+
+// TakeAuthorizationRequest is like the TakeAuthorizationRequest function, using the Store provided with WithStore.
+// The Config returned keeps the options of c that a Store does not persist, such as its http.Client.
+func (c *Config) TakeAuthorizationRequest(state string) (Config, error) {
+	if c.store == nil {
+		return Config{}, errors.New("no store to take the authorization request from, see WithStore")
+	}
+
+	pending, err := TakeAuthorizationRequest(c.store, state)
+	if err != nil {
+		return Config{}, err
+	}
+
+	pending.client = c.client
+	pending.store = c.store
+	pending.random = c.random
+	pending.stateGenerator = c.stateGenerator
+	pending.introspectionAuth = c.introspectionAuth
+
+	return pending, nil
+}
+
+// TakeAuthorizationRequest removes the login pending under state from s and returns its Config, ready for
+// TokenExchange. It is removed in a single step, so concurrent or replayed callbacks cannot both complete it, and
+// the error distinguishes an unknown state from one that has expired or already been used.
+func TakeAuthorizationRequest(s Store, state string) (Config, error) {
+	c, err := s.Take(state)
+
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return Config{}, ErrStateUnknown
+	case errors.Is(err, ErrTaken):
+		return Config{}, ErrStateReused
+	case err != nil:
+		return Config{}, err
+	}
+
+	if c.stateExpired() {
+		return Config{}, ErrStateExpired
+	}
+
+	return c, nil
+}
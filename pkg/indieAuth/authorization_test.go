@@ -0,0 +1,128 @@
+package indieAuth
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestNewAuthorizationRequest(t *testing.T) {
+	c := Config{
+		ClientID:    "https://app.example.com/",
+		RedirectURL: "https://app.example.com/redirect",
+		Endpoint:    Endpoint{AuthURL: "https://example.com/auth"},
+		Identifier:  Identifier{ProfileURL: "https://example.com/"},
+		Scopes:      Scopes{ScopeProfile},
+	}
+
+	first, err := c.NewAuthorizationRequest()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	second, err := c.NewAuthorizationRequest(ScopeProfile, ScopeCreate)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if first.State == "" || first.State == second.State {
+		t.Errorf("Expected a distinct state per request, got %q and %q", first.State, second.State)
+	}
+	if first.Config.Verifier == second.Config.Verifier {
+		t.Errorf("Expected a distinct verifier per request")
+	}
+	if c.State != "" || c.Verifier != "" {
+		t.Errorf("Expected the Config starting requests to be left untouched, got %+v", c)
+	}
+
+	u, err := url.Parse(second.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := u.Query().Get("state"); got != second.State {
+		t.Errorf("state = %q, want %q", got, second.State)
+	}
+	if got := u.Query().Get("scope"); got != "profile create" {
+		t.Errorf("scope = %q, want %q", got, "profile create")
+	}
+	if second.Config.State != second.State {
+		t.Errorf("Config.State = %q, want %q", second.Config.State, second.State)
+	}
+}
+
+func TestTakeAuthorizationRequest(t *testing.T) {
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	s := NewMemoryStore(time.Hour)
+	c := Config{Endpoint: Endpoint{AuthURL: "https://example.com/auth"}, Scopes: Scopes{ScopeProfile}}
+
+	current, err := c.NewAuthorizationRequest()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	stale, err := c.NewAuthorizationRequest()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	stale.Config.StateIssued = now.Add(-DefaultStateExpiry)
+
+	for _, req := range []AuthorizationRequest{current, stale} {
+		if err := s.Save(req.State, req.Config); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if got, err := TakeAuthorizationRequest(s, current.State); err != nil || got.State != current.State {
+		t.Errorf("TakeAuthorizationRequest() = %+v, %v, want state %v", got, err, current.State)
+	}
+
+	tests := []struct {
+		name    string
+		state   string
+		wantErr error
+	}{
+		{"Reused state", current.State, ErrStateReused},
+		{"Expired state", stale.State, ErrStateExpired},
+		{"Unknown state", "unknown", ErrStateUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := TakeAuthorizationRequest(s, tt.state); !errors.Is(err, tt.wantErr) {
+				t.Errorf("TakeAuthorizationRequest() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithStore(t *testing.T) {
+	client := &http.Client{}
+	s := NewMemoryStore(time.Hour)
+	c := Config{Endpoint: Endpoint{AuthURL: "https://example.com/auth"}, Scopes: Scopes{ScopeProfile}}
+	WithStore(s)(&c)
+	WithHTTPClient(client)(&c)
+
+	req, err := c.NewAuthorizationRequest()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	got, err := c.TakeAuthorizationRequest(req.State)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got.Verifier != req.Config.Verifier || got.httpClient() != client {
+		t.Errorf("TakeAuthorizationRequest() = %+v, want the saved login with the caller's http.Client", got)
+	}
+
+	if _, err := c.TakeAuthorizationRequest(req.State); !errors.Is(err, ErrStateReused) {
+		t.Errorf("Expected ErrStateReused, got %v", err)
+	}
+
+	if _, err := (&Config{}).TakeAuthorizationRequest(req.State); err == nil {
+		t.Errorf("Expected an error without a store")
+	}
+}
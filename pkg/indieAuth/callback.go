@@ -0,0 +1,48 @@
+package indieAuth
+
+import (
+	"errors"
+	"net/url"
+)
+
+// Callback is the authorization server's response on the redirect URL.
+// https://indieauth.spec.indieweb.org/#authorization-response
+type Callback struct {
+	Code  string
+	State string
+	// Issuer is the `iss` parameter, which servers that don't publish metadata may omit.
+	Issuer string
+	// Me is optional, and only a hint until the code has been redeemed.
+	Me string
+}
+
+// ParseCallback reads the query parameters of a request to the redirect URL. When the authorization server
+// redirected back with an error, such as the user denying the request, an *OAuthError is returned along with the
+// State and Issuer, so the pending login can be matched and discarded. An error without a known state may be forged.
+// https://www.rfc-editor.org/rfc/rfc6749#section-4.1.2.1
+func ParseCallback(query url.Values) (Callback, error) {
+	if code := query.Get("error"); code != "" {
+		return Callback{State: query.Get("state"), Issuer: query.Get("iss")}, &OAuthError{
+			Code:        code,
+			Description: query.Get("error_description"),
+			URI:         query.Get("error_uri"),
+		}
+	}
+
+	callback := Callback{
+		Code:   query.Get("code"),
+		State:  query.Get("state"),
+		Issuer: query.Get("iss"),
+		Me:     query.Get("me"),
+	}
+
+	if callback.Code == "" {
+		return Callback{}, errors.New("redirect is missing the `code` parameter")
+	}
+
+	if callback.State == "" {
+		return Callback{}, errors.New("redirect is missing the `state` parameter")
+	}
+
+	return callback, nil
+}
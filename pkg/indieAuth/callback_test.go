@@ -0,0 +1,67 @@
+package indieAuth
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestParseCallback(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    Callback
+		wantErr error
+	}{
+		{
+			name:  "Authorization code",
+			query: "code=code&state=state&iss=https%3A%2F%2Fexample.com%2F&me=https%3A%2F%2Fexample.com%2F",
+			want:  Callback{Code: "code", State: "state", Issuer: "https://example.com/", Me: "https://example.com/"},
+		},
+		{
+			name:    "Access denied",
+			query:   "error=access_denied&error_description=The+user+denied+the+request&state=state",
+			want:    Callback{State: "state"},
+			wantErr: &OAuthError{Code: "access_denied", Description: "The user denied the request"},
+		},
+		{
+			name:    "Missing code",
+			query:   "state=state",
+			wantErr: errors.New("redirect is missing the `code` parameter"),
+		},
+		{
+			name:    "Missing state",
+			query:   "code=code",
+			wantErr: errors.New("redirect is missing the `state` parameter"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			got, err := ParseCallback(query)
+
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if got != tt.want {
+					t.Errorf("ParseCallback() = %+v, want %+v", got, tt.want)
+				}
+				return
+			}
+
+			if err == nil || err.Error() != tt.wantErr.Error() {
+				t.Errorf("ParseCallback() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseCallback() = %+v, want %+v", got, tt.want)
+			}
+
+			var oauthErr *OAuthError
+			if errors.As(tt.wantErr, &oauthErr) && !errors.As(err, &oauthErr) {
+				t.Errorf("Expected an *OAuthError, got %T", err)
+			}
+		})
+	}
+}
@@ -5,12 +5,14 @@ import (
 	"os"
 )
 
+// Conf is the yaml representation of a client's identifier and redirect URL.
 type Conf struct {
 	RedirectURL string `yaml:"RedirectURL"`
 	URL         string `yaml:"URL"`
 }
 
-func loadConfig(filepath string) (*Conf, error) {
+// LoadConfig reads a Conf from the yaml file at filepath, for use with WithConf.
+func LoadConfig(filepath string) (*Conf, error) {
 	configFile, err := os.ReadFile(filepath)
 	if err != nil {
 		return nil, err
@@ -23,7 +23,7 @@ func TestLoadConfig(t *testing.T) {
 	}
 
 	// Load the configuration
-	conf, err := loadConfig(tmpfile.Name())
+	conf, err := LoadConfig(tmpfile.Name())
 	if err != nil {
 		t.Fatal(err)
 	}
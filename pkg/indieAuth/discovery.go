@@ -0,0 +1,362 @@
+package indieAuth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// authServerMetadata is the IndieAuth Server Metadata document advertised via rel="indieauth-metadata".
+// https://indieauth.spec.indieweb.org/#indieauth-server-metadata
+type authServerMetadata struct {
+	Issuer                        string   `json:"issuer"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	IntrospectionEndpoint         string   `json:"introspection_endpoint"`
+	RevocationEndpoint            string   `json:"revocation_endpoint"`
+	UserinfoEndpoint              string   `json:"userinfo_endpoint"`
+	ScopesSupported               []string `json:"scopes_supported"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
+	IssParameterSupported         bool     `json:"authorization_response_iss_parameter_supported"`
+}
+
+// Links maps each rel value found on a profile URL to its targets, in document order,
+// with HTTP Link headers ahead of any elements in the HTML.
+type Links map[string][]string
+
+// Get returns the first target for rel, which is the one the spec says to honor.
+func (l Links) Get(rel string) string {
+	if len(l[rel]) == 0 {
+		return ""
+	}
+	return l[rel][0]
+}
+
+// discoveryResult is everything learned from fetching a user's profile URL.
+type discoveryResult struct {
+	// ProfileURL is the canonical profile URL after following any permanent redirects.
+	ProfileURL string
+	Endpoint   Endpoint
+	Links      Links
+}
+
+func discover(ctx context.Context, client *http.Client, profileURL string) (discoveryResult, error) {
+
+	req, err := http.NewRequestWithContext(ctx, "GET", profileURL, nil)
+
+	if err != nil {
+		return discoveryResult{}, err
+	}
+
+	// Permanent redirects update the canonical profile URL, but only until the first temporary redirect.
+	// https://indieauth.spec.indieweb.org/#redirect-examples
+	canonicalURL := profileURL
+	temporaryRedirect := false
+	checkRedirect := client.CheckRedirect
+	redirectClient := *client
+	redirectClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if checkRedirect != nil {
+			if err := checkRedirect(req, via); err != nil {
+				return err
+			}
+		} else if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+
+		status := req.Response.StatusCode
+		if !temporaryRedirect && (status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect) {
+			canonicalURL = req.URL.String()
+		} else {
+			temporaryRedirect = true
+		}
+
+		return nil
+	}
+
+	resp, err := do(&redirectClient, req)
+
+	if err != nil {
+		return discoveryResult{}, err
+	}
+
+	defer resp.Body.Close()
+
+	// HTTP Link headers MUST be checked before any link elements in the document.
+	links := parseLinkHeaders(resp.Header.Values("Link"), resp.Request.URL)
+	for rel, hrefs := range parseLinkElements(resp.Body, resp.Request.URL) {
+		links[rel] = append(links[rel], hrefs...)
+	}
+
+	// Servers publishing metadata take precedence over the legacy link rels.
+	if metadataURL := links.Get("indieauth-metadata"); metadataURL != "" {
+		if err := validateEndpointURL(metadataURL); err != nil {
+			return discoveryResult{}, err
+		}
+		endpoint, err := discoverMetadata(ctx, client, metadataURL)
+		if err != nil {
+			return discoveryResult{}, err
+		}
+		return newDiscoveryResult(profileURL, canonicalURL, endpoint, links)
+	}
+
+	// A token endpoint is only needed for authorization, authentication alone can be performed without one.
+	if links.Get("authorization_endpoint") != "" {
+		endpoint := Endpoint{
+			AuthURL:  links.Get("authorization_endpoint"),
+			TokenURL: links.Get("token_endpoint"),
+		}
+		if err := endpoint.validate(); err != nil {
+			return discoveryResult{}, err
+		}
+		return newDiscoveryResult(profileURL, canonicalURL, endpoint, links)
+	}
+
+	return discoveryResult{}, errors.New("unable to find link header for `indieauth-metadata` or link header for `rel=authorization_endpoint`")
+}
+
+func newDiscoveryResult(profileURL string, canonicalURL string, endpoint Endpoint, links Links) (discoveryResult, error) {
+	if canonicalURL != profileURL {
+		id, err := newUserIdentifier(canonicalURL)
+		if err != nil {
+			return discoveryResult{}, fmt.Errorf("permanently redirected to an invalid profile URL %v: %w", canonicalURL, err)
+		}
+		canonicalURL = id.ProfileURL
+	}
+
+	return discoveryResult{
+		ProfileURL: canonicalURL,
+		Endpoint:   endpoint,
+		Links:      links,
+	}, nil
+}
+
+// parseLinkHeaders returns the targets of the Link header values found for each rel value, resolved against base.
+// https://www.rfc-editor.org/rfc/rfc8288#section-3
+func parseLinkHeaders(headers []string, base *url.URL) Links {
+	links := make(Links)
+
+	for _, header := range headers {
+		for _, value := range splitLinkHeader(header) {
+			value = strings.TrimSpace(value)
+			if !strings.HasPrefix(value, "<") {
+				continue
+			}
+			end := strings.Index(value, ">")
+			if end == -1 {
+				continue
+			}
+
+			target, err := base.Parse(strings.TrimSpace(value[1:end]))
+			if err != nil {
+				continue
+			}
+
+			for _, param := range strings.Split(value[end+1:], ";") {
+				key, val, found := strings.Cut(param, "=")
+				if !found || !strings.EqualFold(strings.TrimSpace(key), "rel") {
+					continue
+				}
+
+				for _, rel := range parseRel(strings.Trim(strings.TrimSpace(val), `"`)) {
+					links[rel] = append(links[rel], target.String())
+				}
+			}
+		}
+	}
+
+	return links
+}
+
+// splitLinkHeader splits a Link header on the commas separating link values, ignoring commas within a URI or a quoted parameter.
+func splitLinkHeader(header string) []string {
+	var values []string
+	inURI, inQuote := false, false
+	start := 0
+
+	for i, r := range header {
+		switch {
+		case r == '<' && !inQuote:
+			inURI = true
+		case r == '>' && !inQuote:
+			inURI = false
+		case r == '"' && !inURI:
+			inQuote = !inQuote
+		case r == ',' && !inURI && !inQuote:
+			values = append(values, header[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(values, header[start:])
+}
+
+// anchorRels are the rel values honored on <a> elements. Endpoints may only be advertised by Link headers and
+// <link> elements, so that links in page content, such as comments, cannot choose the user's authorization server.
+// https://indieauth.spec.indieweb.org/#discovery-by-clients
+var anchorRels = map[string]bool{
+	"me": true,
+}
+
+// parseLinkElements returns the hrefs of the <link> elements, and of the <a> elements for anchorRels, found for
+// each rel value. Each href is resolved against the document's <base href>, if present, or the document URL.
+func parseLinkElements(r io.Reader, documentURL *url.URL) Links {
+	links := make(Links)
+	base := documentURL
+	baseFound := false
+	responseTokens := html.NewTokenizer(r)
+
+	for tokenType := responseTokens.Next(); tokenType != html.ErrorToken; tokenType = responseTokens.Next() {
+		if tokenType != html.StartTagToken && tokenType != html.SelfClosingTagToken {
+			continue
+		}
+
+		token := responseTokens.Token()
+		if token.DataAtom == atom.Base && !baseFound {
+			if href, ok := attr(token, "href"); ok {
+				if u, err := documentURL.Parse(strings.TrimSpace(href)); err == nil {
+					base = u
+					baseFound = true
+				}
+			}
+			continue
+		}
+
+		if token.DataAtom != atom.Link && token.DataAtom != atom.A {
+			continue
+		}
+
+		href, hasHref := attr(token, "href")
+		rel, _ := attr(token, "rel")
+		if !hasHref {
+			continue
+		}
+
+		for _, r := range parseRel(rel) {
+			if token.DataAtom == atom.A && !anchorRels[r] {
+				continue
+			}
+			links[r] = append(links[r], strings.TrimSpace(href))
+		}
+	}
+
+	// The first <base href> applies to the whole document, wherever it appears.
+	for rel, hrefs := range links {
+		resolved := hrefs[:0]
+		for _, href := range hrefs {
+			if u, err := base.Parse(href); err == nil {
+				resolved = append(resolved, u.String())
+			}
+		}
+		links[rel] = resolved
+	}
+
+	return links
+}
+
+// parseRel splits a rel attribute into its space-separated, case-insensitive link types.
+func parseRel(rel string) []string {
+	return strings.Fields(strings.ToLower(rel))
+}
+
+func attr(token html.Token, key string) (string, bool) {
+	for _, a := range token.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func discoverMetadata(ctx context.Context, client *http.Client, metadataURL string) (Endpoint, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", metadataURL, nil)
+
+	if err != nil {
+		return Endpoint{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := do(client, req)
+
+	if err != nil {
+		return Endpoint{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Endpoint{}, fmt.Errorf("received status code of %v when fetching metadata from %v", resp.StatusCode, metadataURL)
+	}
+
+	metadata := &authServerMetadata{}
+	err = json.NewDecoder(resp.Body).Decode(metadata)
+
+	if err != nil {
+		return Endpoint{}, err
+	}
+
+	if metadata.Issuer == "" || !strings.HasPrefix(metadataURL, metadata.Issuer) {
+		return Endpoint{}, fmt.Errorf("metadata issuer %q MUST be a prefix of the metadata URL %v", metadata.Issuer, metadataURL)
+	}
+
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" {
+		return Endpoint{}, errors.New("metadata MUST contain both an `authorization_endpoint` and a `token_endpoint`")
+	}
+
+	endpoint := Endpoint{
+		AuthURL:                       metadata.AuthorizationEndpoint,
+		TokenURL:                      metadata.TokenEndpoint,
+		MetadataURL:                   metadataURL,
+		Issuer:                        metadata.Issuer,
+		IntrospectionURL:              metadata.IntrospectionEndpoint,
+		RevocationURL:                 metadata.RevocationEndpoint,
+		UserinfoURL:                   metadata.UserinfoEndpoint,
+		ScopesSupported:               metadata.ScopesSupported,
+		CodeChallengeMethodsSupported: metadata.CodeChallengeMethodsSupported,
+		IssParameterSupported:         metadata.IssParameterSupported,
+	}
+
+	if err := endpoint.validate(); err != nil {
+		return Endpoint{}, err
+	}
+
+	return endpoint, nil
+}
+
+// validate ensures every discovered endpoint is an absolute http(s) URL.
+func (e Endpoint) validate() error {
+	endpoints := []string{e.AuthURL, e.TokenURL, e.MetadataURL, e.IntrospectionURL, e.RevocationURL, e.UserinfoURL}
+
+	for _, endpoint := range endpoints {
+		if endpoint == "" {
+			continue
+		}
+		if err := validateEndpointURL(endpoint); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateEndpointURL(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+
+	if !u.IsAbs() || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("endpoint %v MUST be an absolute URL using 'http' or 'https'", endpoint)
+	}
+
+	if u.Hostname() == "" {
+		return fmt.Errorf("endpoint %v has no Hostname", endpoint)
+	}
+
+	return nil
+}
@@ -0,0 +1,272 @@
+package indieAuth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiscoveryAuthServer(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/zietlow.io/" {
+			// Markup as served by https://zietlow.io/
+			w.Write([]byte(`<link rel="authorization_endpoint" href="https://indieauth.com/auth"><link rel="token_endpoint" href="https://tokens.indieauth.com/token"><a href="https://github.com/aczietlow" rel="me">Github</a>`))
+			return
+		}
+		w.Write([]byte(`<a href="f00"> </a><link href="http://localhost/auth" rel="authorization_endpoint"><link href="http://localhost/token" rel="token_endpoint">`))
+	}))
+	defer ts.Close()
+
+	type TestUrl struct {
+		sourceUrl        string
+		authEndpointUrl  string
+		tokenEndpointUrl string
+	}
+
+	tests := []TestUrl{
+		{
+			sourceUrl:        ts.URL,
+			authEndpointUrl:  "http://localhost/auth",
+			tokenEndpointUrl: "http://localhost/token",
+		},
+		{
+			sourceUrl:        ts.URL + "/zietlow.io/",
+			authEndpointUrl:  "https://indieauth.com/auth",
+			tokenEndpointUrl: "https://tokens.indieauth.com/token",
+		},
+	}
+
+	for _, Url := range tests {
+		result, err := discover(context.Background(), ts.Client(), Url.sourceUrl)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if result.Endpoint.AuthURL != Url.authEndpointUrl {
+			t.Errorf("Parsed %v Expected '%v', got '%s'", Url.sourceUrl, Url.authEndpointUrl, result.Endpoint.AuthURL)
+		}
+		if result.Endpoint.TokenURL != Url.tokenEndpointUrl {
+			t.Errorf("Parsed %v Expected '%v', got '%s'", Url.sourceUrl, Url.tokenEndpointUrl, result.Endpoint.TokenURL)
+		}
+	}
+}
+
+func TestDiscoveryAuthServerMetadata(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/oauth-authorization-server":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{
+				"issuer": "` + ts.URL + `/",
+				"authorization_endpoint": "` + ts.URL + `/auth",
+				"token_endpoint": "` + ts.URL + `/token",
+				"introspection_endpoint": "` + ts.URL + `/introspect",
+				"revocation_endpoint": "` + ts.URL + `/revoke",
+				"userinfo_endpoint": "` + ts.URL + `/userinfo",
+				"scopes_supported": ["profile", "email", "create"],
+				"code_challenge_methods_supported": ["S256"],
+				"authorization_response_iss_parameter_supported": true
+			}`))
+		case "/no-issuer":
+			w.Write([]byte(`{"authorization_endpoint": "` + ts.URL + `/auth", "token_endpoint": "` + ts.URL + `/token"}`))
+		case "/broken":
+			w.Write([]byte(`<link rel="indieauth-metadata" href="` + ts.URL + `/no-issuer"><link href="http://localhost/auth" rel="authorization_endpoint"><link href="http://localhost/token" rel="token_endpoint">`))
+		default:
+			w.Write([]byte(`<link rel="indieauth-metadata" href="` + ts.URL + `/.well-known/oauth-authorization-server"><link href="http://localhost/auth" rel="authorization_endpoint"><link href="http://localhost/token" rel="token_endpoint">`))
+		}
+	}))
+	defer ts.Close()
+
+	result, err := discover(context.Background(), ts.Client(), ts.URL+"/")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := Endpoint{
+		AuthURL:                       ts.URL + "/auth",
+		TokenURL:                      ts.URL + "/token",
+		MetadataURL:                   ts.URL + "/.well-known/oauth-authorization-server",
+		Issuer:                        ts.URL + "/",
+		IntrospectionURL:              ts.URL + "/introspect",
+		RevocationURL:                 ts.URL + "/revoke",
+		UserinfoURL:                   ts.URL + "/userinfo",
+		ScopesSupported:               []string{"profile", "email", "create"},
+		CodeChallengeMethodsSupported: []string{"S256"},
+		IssParameterSupported:         true,
+	}
+	if !reflect.DeepEqual(result.Endpoint, want) {
+		t.Errorf("discover() = %v\n, want %v\n", result.Endpoint, want)
+	}
+
+	if _, err := discover(context.Background(), ts.Client(), ts.URL+"/broken"); err == nil {
+		t.Errorf("Expected an error for metadata without an issuer")
+	}
+}
+
+func TestDiscoveryAuthServerLinkHeaders(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", `</auth>; rel="authorization_endpoint me", <https://example.com/a,b>; rel=micropub`)
+		w.Header().Add("Link", `<http://localhost/token>; rel="token_endpoint"`)
+		w.Write([]byte(`<link href="http://localhost/html-auth" rel="authorization_endpoint"><link href="http://localhost/html-token" rel="token_endpoint">`))
+	}))
+	defer ts.Close()
+
+	result, err := discover(context.Background(), ts.Client(), ts.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Endpoint.AuthURL != ts.URL+"/auth" {
+		t.Errorf("Expected '%v', got '%v'", ts.URL+"/auth", result.Endpoint.AuthURL)
+	}
+	if result.Endpoint.TokenURL != "http://localhost/token" {
+		t.Errorf("Expected 'http://localhost/token', got '%v'", result.Endpoint.TokenURL)
+	}
+}
+
+func TestSplitLinkHeader(t *testing.T) {
+	got := splitLinkHeader(`<https://example.com/a,b>; rel="me, you", </token>; rel=token_endpoint`)
+	want := []string{`<https://example.com/a,b>; rel="me, you"`, ` </token>; rel=token_endpoint`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitLinkHeader() = %q, want %q", got, want)
+	}
+}
+
+func TestDiscoveryAuthServerRelativeURLs(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/profile/", http.StatusFound)
+		case "/base/":
+			w.Write([]byte(`<link href="auth" rel="authorization_endpoint"><base href="/indieauth/"><link href="token" rel="token_endpoint">`))
+		case "/invalid/":
+			w.Write([]byte(`<link href="ftp://localhost/auth" rel="authorization_endpoint"><link href="token" rel="token_endpoint">`))
+		default:
+			w.Write([]byte(`<link href="auth" rel="authorization_endpoint"><link href="/token" rel="token_endpoint">`))
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		sourceUrl        string
+		authEndpointUrl  string
+		tokenEndpointUrl string
+		wantErr          bool
+	}{
+		{ts.URL + "/moved", ts.URL + "/profile/auth", ts.URL + "/token", false},
+		{ts.URL + "/base/", ts.URL + "/indieauth/auth", ts.URL + "/indieauth/token", false},
+		{ts.URL + "/invalid/", "", "", true},
+	}
+
+	for _, tt := range tests {
+		result, err := discover(context.Background(), ts.Client(), tt.sourceUrl)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parsed %v error = %v, wantErr %v", tt.sourceUrl, err, tt.wantErr)
+			continue
+		}
+		if result.Endpoint.AuthURL != tt.authEndpointUrl {
+			t.Errorf("Parsed %v Expected '%v', got '%s'", tt.sourceUrl, tt.authEndpointUrl, result.Endpoint.AuthURL)
+		}
+		if result.Endpoint.TokenURL != tt.tokenEndpointUrl {
+			t.Errorf("Parsed %v Expected '%v', got '%s'", tt.sourceUrl, tt.tokenEndpointUrl, result.Endpoint.TokenURL)
+		}
+	}
+}
+
+func TestDiscoverCanonicalProfileURL(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		case "/new":
+			http.Redirect(w, r, "/newer", http.StatusPermanentRedirect)
+		case "/newer":
+			http.Redirect(w, r, "/temp", http.StatusFound)
+		case "/temp":
+			http.Redirect(w, r, "/final", http.StatusMovedPermanently)
+		default:
+			w.Write([]byte(`<link href="/auth" rel="authorization_endpoint"><link href="/token" rel="token_endpoint">`))
+		}
+	}))
+	defer ts.Close()
+
+	// Profile URLs must be domains, so use localhost rather than the loopback address.
+	base := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+
+	tests := []struct {
+		sourceUrl  string
+		profileUrl string
+	}{
+		{base + "/", base + "/"},
+		{base + "/old", base + "/newer"},
+		{base + "/newer", base + "/newer"},
+		{base + "/temp", base + "/final"},
+	}
+
+	for _, tt := range tests {
+		result, err := discover(context.Background(), ts.Client(), tt.sourceUrl)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result.ProfileURL != tt.profileUrl {
+			t.Errorf("Discovered %v Expected profile URL '%v', got '%v'", tt.sourceUrl, tt.profileUrl, result.ProfileURL)
+		}
+		if result.Endpoint.AuthURL != base+"/auth" {
+			t.Errorf("Discovered %v Expected '%v', got '%v'", tt.sourceUrl, base+"/auth", result.Endpoint.AuthURL)
+		}
+	}
+}
+
+func TestDiscoverLinks(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", `</micropub>; rel="micropub"`)
+		w.Write([]byte(`
+			<link rel=" Me   AUTHORIZATION_ENDPOINT " href="/auth">
+			<link rel="authorization_endpoint" href="/other-auth">
+			<link rel="micropub" href="/other-micropub">
+			<link rel="microsub" href="https://aperture.example/microsub/1">
+			<a rel="me token_endpoint" href="https://github.com/example">GitHub</a>
+			<a rel="indieauth-metadata" href="https://attacker.example/metadata">Comment</a>
+			<a href="/no-rel">Home</a>`))
+	}))
+	defer ts.Close()
+
+	result, err := discover(context.Background(), ts.Client(), ts.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := Links{
+		"me":                     []string{ts.URL + "/auth", "https://github.com/example"},
+		"authorization_endpoint": []string{ts.URL + "/auth", ts.URL + "/other-auth"},
+		"micropub":               []string{ts.URL + "/micropub", ts.URL + "/other-micropub"},
+		"microsub":               []string{"https://aperture.example/microsub/1"},
+	}
+	if !reflect.DeepEqual(result.Links, want) {
+		t.Errorf("discover() links = %v\n, want %v\n", result.Links, want)
+	}
+	if result.Endpoint.AuthURL != ts.URL+"/auth" {
+		t.Errorf("Expected '%v', got '%v'", ts.URL+"/auth", result.Endpoint.AuthURL)
+	}
+	if result.Endpoint.TokenURL != "" {
+		t.Errorf("Expected no token endpoint from an <a> element, got '%v'", result.Endpoint.TokenURL)
+	}
+}
+
+func TestDiscoverContextCanceled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := discover(ctx, ts.Client(), ts.URL); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package indieAuth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+var (
+	// ErrStateMismatch is returned when the state returned by the authorization server is not the one sent.
+	ErrStateMismatch = errors.New("state value does not match")
+	// ErrStateUnknown is returned when no login is pending for the state returned by the authorization server.
+	ErrStateUnknown = errors.New("state value is unknown")
+	// ErrStateExpired is returned when the state returned is older than the Config's StateExpiry.
+	ErrStateExpired = errors.New("state value has expired")
+	// ErrStateReused is returned when the state returned has already been used to complete a login.
+	ErrStateReused = errors.New("state value has already been used")
+	// ErrIssuerMismatch is returned when the issuer returned by the authorization server is not the one discovered.
+	ErrIssuerMismatch = errors.New("issuer value does not match")
+	// ErrMeMismatch is returned when the authorization server vouches for a different user than was discovered.
+	ErrMeMismatch = errors.New("me value does not match")
+)
+
+// MeMismatchError is returned when the `me` returned by the authorization server cannot be trusted in place of the
+// profile URL that was discovered. It matches ErrMeMismatch with errors.Is.
+type MeMismatchError struct {
+	// ProfileURL is the profile URL the authorization server was discovered from.
+	ProfileURL string
+	// Me is the profile URL the authorization server returned.
+	Me  string
+	Err error
+}
+
+func (e *MeMismatchError) Error() string {
+	return fmt.Sprintf("authorization server returned me %v, which does not match the profile URL %v: %v", e.Me, e.ProfileURL, e.Err)
+}
+
+func (e *MeMismatchError) Unwrap() []error {
+	return []error{ErrMeMismatch, e.Err}
+}
+
+// OAuthError is an error response from the authorization server.
+// https://www.rfc-editor.org/rfc/rfc6749#section-5.2
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+	URI         string `json:"error_uri"`
+	// StatusCode is the HTTP status of the response, or zero when the error was received on the redirect URL.
+	StatusCode int `json:"-"`
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return fmt.Sprintf("authorization server responded with error %v", e.Code)
+	}
+	return fmt.Sprintf("authorization server responded with error %v: %v", e.Code, e.Description)
+}
+
+// newResponseError reads an unsuccessful response from the authorization server, returning an *OAuthError when
+// the body, JSON or form-encoded, carries an `error` code.
+func newResponseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	oauthError := &OAuthError{StatusCode: resp.StatusCode}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "application/json":
+		json.Unmarshal(body, oauthError)
+	case "application/x-www-form-urlencoded", "text/plain":
+		if values, err := url.ParseQuery(string(body)); err == nil {
+			oauthError.Code = values.Get("error")
+			oauthError.Description = values.Get("error_description")
+			oauthError.URI = values.Get("error_uri")
+		}
+	}
+
+	if oauthError.Code == "" {
+		return fmt.Errorf("received status code of %v from %v", resp.StatusCode, resp.Request.URL)
+	}
+
+	return oauthError
+}
@@ -0,0 +1,215 @@
+package indieAuth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOAuthError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/json":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "invalid_grant", "error_description": "The code has expired", "error_uri": "https://example.com/errors"}`))
+		case "/form":
+			w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`error=invalid_request&error_description=Missing+%27code%27+parameter`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		path string
+		want *OAuthError
+	}{
+		{"/json", &OAuthError{Code: "invalid_grant", Description: "The code has expired", URI: "https://example.com/errors", StatusCode: http.StatusBadRequest}},
+		{"/form", &OAuthError{Code: "invalid_request", Description: "Missing 'code' parameter", StatusCode: http.StatusBadRequest}},
+		{"/unknown", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			_, err := getTokenURLResponse(context.Background(), ts.Client(), ts.URL+tt.path, url.Values{})
+			if err == nil {
+				t.Fatalf("Expected an error")
+			}
+
+			var oauthErr *OAuthError
+			if errors.As(err, &oauthErr) != (tt.want != nil) {
+				t.Fatalf("Unexpected error type %T: %v", err, err)
+			}
+			if tt.want != nil && *oauthErr != *tt.want {
+				t.Errorf("Expected %+v, got %+v", *tt.want, *oauthErr)
+			}
+		})
+	}
+}
+
+func TestTokenExchangeStateMismatch(t *testing.T) {
+	c := Config{State: "state"}
+
+	_, err := c.TokenExchange("other_state", "code", "")
+	if !errors.Is(err, ErrStateMismatch) {
+		t.Errorf("Expected ErrStateMismatch, got %v", err)
+	}
+}
+
+func TestVerifyCallbackState(t *testing.T) {
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	tests := []struct {
+		name    string
+		config  Config
+		state   string
+		wantErr error
+	}{
+		{"Matching state", Config{State: "state", StateIssued: now}, "state", nil},
+		{"Mismatched state", Config{State: "state", StateIssued: now}, "other_state", ErrStateMismatch},
+		{"Empty state", Config{State: "state", StateIssued: now}, "", ErrStateMismatch},
+		{"No state issued", Config{}, "", ErrStateUnknown},
+		{"Used state", Config{StateIssued: now}, "state", ErrStateReused},
+		{"Expired state", Config{State: "state", StateIssued: now.Add(-DefaultStateExpiry)}, "state", ErrStateExpired},
+		{"Custom expiry", Config{State: "state", StateIssued: now.Add(-time.Minute), StateExpiry: time.Hour}, "state", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.verifyCallback(tt.state, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("verifyCallback() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	c := Config{State: "state", StateIssued: now}
+	if err := c.verifyCallback("state", ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := c.verifyCallback("state", ""); !errors.Is(err, ErrStateReused) {
+		t.Errorf("Expected the state to be consumed, got %v", err)
+	}
+}
+
+func TestRequestURLAfterStateConsumed(t *testing.T) {
+	c := Config{Endpoint: Endpoint{AuthURL: "https://example.com/auth"}, Scopes: Scopes{ScopeProfile}, State: "state"}
+	if err := c.verifyCallback("state", ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	builders := map[string]func() (string, error){
+		"Authorization":  func() (string, error) { return c.GetAuthorizationRequestURL() },
+		"Authentication": c.GetAuthenticationRequestURL,
+	}
+
+	for name, build := range builders {
+		t.Run(name, func(t *testing.T) {
+			c.State = ""
+			authURL, err := build()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			u, err := url.Parse(authURL)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if state := u.Query().Get("state"); state == "" || state != c.State || c.StateIssued.IsZero() {
+				t.Errorf("Expected a new state to be issued, got %q", state)
+			}
+			if err := c.verifyCallback(c.State, ""); err != nil {
+				t.Errorf("Expected the new state to be accepted, got %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyCallbackIssuer(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint Endpoint
+		iss      string
+		wantErr  bool
+	}{
+		{"Matching issuer", Endpoint{Issuer: "https://example.com/"}, "https://example.com/", false},
+		{"Mismatched issuer", Endpoint{Issuer: "https://example.com/"}, "https://attacker.example/", true},
+		{"Escaped issuer", Endpoint{Issuer: "https://example.com/"}, "https%3A%2F%2Fexample.com%2F", true},
+		{"Optional issuer omitted", Endpoint{Issuer: "https://example.com/"}, "", false},
+		{"Required issuer omitted", Endpoint{Issuer: "https://example.com/", IssParameterSupported: true}, "", true},
+		{"No metadata", Endpoint{}, "https://example.com/", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Config{Endpoint: tt.endpoint, State: "state"}
+			err := c.verifyCallback("state", tt.iss)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyCallback() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrIssuerMismatch) {
+				t.Errorf("Expected ErrIssuerMismatch, got %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyMe(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/other-server/":
+			w.Write([]byte(`<link rel="authorization_endpoint" href="https://attacker.example/auth">`))
+		default:
+			w.Write([]byte(`<link rel="authorization_endpoint" href="https://example.com/auth">`))
+		}
+	}))
+	defer ts.Close()
+
+	// Profile URLs must be domains, so use localhost rather than the loopback address.
+	base := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+
+	tests := []struct {
+		name    string
+		me      string
+		want    string
+		wantErr bool
+	}{
+		{"Same profile URL", base + "/", base + "/", false},
+		{"Same authorization server", base + "/user/", base + "/user/", false},
+		{"Different authorization server", base + "/other-server/", base + "/", true},
+		{"Invalid profile URL", "https://example.com/#me", base + "/", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Config{
+				Endpoint:   Endpoint{AuthURL: "https://example.com/auth"},
+				Identifier: Identifier{ProfileURL: base + "/"},
+				client:     ts.Client(),
+			}
+
+			err := c.verifyMe(context.Background(), tt.me)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifyMe() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var mismatch *MeMismatchError
+			if err != nil && (!errors.Is(err, ErrMeMismatch) || !errors.As(err, &mismatch) || mismatch.Me != tt.me || mismatch.ProfileURL != base+"/") {
+				t.Errorf("Expected a MeMismatchError for %v, got %v", tt.me, err)
+			}
+			if c.Identifier.ProfileURL != tt.want {
+				t.Errorf("Expected profile URL '%v', got '%v'", tt.want, c.Identifier.ProfileURL)
+			}
+		})
+	}
+}
@@ -6,8 +6,13 @@ import (
 	"net/url"
 )
 
+// Identifier is the user's profile URL. Once discovery has run, ProfileURL is the canonical `me` value,
+// having followed any permanent redirects from the URL the user entered. Links holds every rel discovered on the
+// profile, such as `me`, `micropub` and `microsub`. Profile is filled in when the `profile` scope was granted.
 type Identifier struct {
 	ProfileURL string
+	Links      Links
+	Profile    Profile
 }
 
 func newUserIdentifier(profileURL string) (Identifier, error) {
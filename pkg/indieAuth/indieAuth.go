@@ -1,20 +1,20 @@
 package indieAuth
 
 import (
-	"crypto/rand"
+	"context"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"golang.org/x/net/html"
-	"golang.org/x/net/html/atom"
 	"io"
+	"mime"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
-	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -22,152 +22,154 @@ type Config struct {
 	Endpoint    Endpoint
 	Identifier  Identifier
 	RedirectURL string
-	State       string
+	// Scopes are the scopes requested by the most recent authorization request.
+	Scopes Scopes
+	State  string
+	// StateIssued is when State was generated. The state is accepted once, within StateExpiry of being issued,
+	// and cleared when TokenExchange or Authenticate consume it.
+	StateIssued time.Time
+	// StateExpiry defaults to DefaultStateExpiry, see WithStateExpiry.
+	StateExpiry time.Duration
 	Verifier    string
 	Token       Token
+
+	client         *http.Client
+	store          Store
+	random         io.Reader
+	stateGenerator func() (string, error)
+	// introspectionAuth authorizes requests to the introspection endpoint, see WithIntrospectionAuth.
+	introspectionAuth func(*http.Request)
 }
 
 type Endpoint struct {
-	AuthURL     string
-	TokenURL    string
-	MetadataURL string
+	AuthURL                       string
+	TokenURL                      string
+	MetadataURL                   string
+	Issuer                        string
+	IntrospectionURL              string
+	RevocationURL                 string
+	UserinfoURL                   string
+	ScopesSupported               []string
+	CodeChallengeMethodsSupported []string
+	// IssParameterSupported is set when the server promises to include `iss` in every authorization response.
+	IssParameterSupported bool
 }
 
 type Token struct {
 	AuthorizationCode string
 	AccessToken       string
-	Expires           int
-	RefreshToken      string
-	Scope             []string
+	// Expires is the lifetime in seconds reported by the server, and Expiry the absolute time it elapses.
+	// A zero Expiry means the server did not say when the token expires.
+	Expires      int
+	Expiry       time.Time
+	RefreshToken string
+	// Scope is the set of scopes granted, which may be fewer than were requested.
+	Scope Scopes
 }
 
 type TokenResponseParams struct {
-	AccessToken string `json:"access_token"`
-	Me          string `json:"me"`
-	Scope       string `json:"scope"`
-	//Profiles	any
-	Expires      int    `json:"expires_in"`
-	RefreshToken string `json:"refresh_token"`
+	AccessToken  string   `json:"access_token"`
+	TokenType    string   `json:"token_type"`
+	Me           string   `json:"me"`
+	Scope        string   `json:"scope"`
+	Profile      *Profile `json:"profile,omitempty"`
+	Expires      int      `json:"expires_in"`
+	RefreshToken string   `json:"refresh_token"`
 }
 
-func New(ProfileURL string) (Config, error) {
-	id, err := newUserIdentifier(ProfileURL)
+func New(ProfileURL string, opts ...Option) (Config, error) {
+	return NewContext(context.Background(), ProfileURL, opts...)
+}
 
-	if err != nil {
-		return Config{}, err
+// NewContext is like New, but the discovery requests to the profile URL and the authorization server
+// are bound to ctx.
+func NewContext(ctx context.Context, ProfileURL string, opts ...Option) (Config, error) {
+	c := Config{
+		Scopes: Scopes{ScopeProfile, ScopeEmail},
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	if c.ClientID == "" || c.RedirectURL == "" {
+		return Config{}, errors.New("a client ID and redirect URL are required, see WithClientID and WithRedirectURL")
 	}
 
-	endpoint, err := discoveryAuthServer(id.ProfileURL)
+	id, err := newUserIdentifier(ProfileURL)
 
 	if err != nil {
 		return Config{}, err
 	}
 
-	runTimeConf, err := loadConfig("./config.yaml")
+	discovered, err := discover(ctx, c.httpClient(), id.ProfileURL)
 
 	if err != nil {
 		return Config{}, err
 	}
+	id.ProfileURL = discovered.ProfileURL
+	id.Links = discovered.Links
 
-	state, err := generateState(10)
+	state, err := c.newState()
 	if err != nil {
 		return Config{}, err
 	}
 
-	return Config{
-		ClientID:    runTimeConf.URL,
-		Endpoint:    endpoint,
-		Identifier:  id,
-		RedirectURL: runTimeConf.RedirectURL,
-		State:       state,
-		Verifier:    "",
-		Token:       Token{},
-	}, nil
-}
+	c.Endpoint = discovered.Endpoint
+	c.Identifier = id
+	c.State = state
+	c.StateIssued = timeNow()
 
-// @TODO add support for newer metadata endpoints as well.
-func discoveryAuthServer(url string) (Endpoint, error) {
+	return c, nil
+}
 
-	req, err := http.NewRequest("GET", url, nil)
+// GetAuthorizationRequestURL builds the URL to send the user to. The scopes requested default to c.Scopes, and
+// are validated against the `scopes_supported` by the authorization server when it publishes metadata.
+// A new state is issued when the last one has been consumed by TokenExchange or Authenticate.
+func (c *Config) GetAuthorizationRequestURL(scopes ...Scope) (string, error) {
+	if len(scopes) > 0 {
+		c.Scopes = scopes
+	}
 
-	if err != nil {
-		return Endpoint{}, err
+	if len(c.Scopes) == 0 {
+		return "", errors.New("at least one scope is required to request authorization, see GetAuthenticationRequestURL")
 	}
 
-	//debug(httputil.DumpRequestOut(req, true))
-	resp, err := (&http.Client{}).Do(req)
+	err := c.Scopes.Validate(c.Endpoint.ScopesSupported)
 
 	if err != nil {
-		return Endpoint{}, err
+		return "", err
 	}
 
-	defer resp.Body.Close()
-	responseTokens := html.NewTokenizer(resp.Body)
-	var endpointURL string
-	endpoint := Endpoint{}
-
-	for tokenType := responseTokens.Next(); tokenType != html.ErrorToken; {
-		if tokenType == html.StartTagToken {
-			token := responseTokens.Token()
-
-			if token.DataAtom != atom.Link {
-				tokenType = responseTokens.Next()
-				continue
-			}
-			endpointURL = ""
-			isAuthEndpoint := false
-			isTokenEndpoint := false
-			for _, a := range token.Attr {
-				if a.Key == "href" {
-					endpointURL = a.Val
-					continue
-				}
-				if a.Key == "rel" && a.Val == "authorization_endpoint" {
-					isAuthEndpoint = true
-					continue
-				}
-				if a.Key == "rel" && a.Val == "token_endpoint" {
-					isTokenEndpoint = true
-					continue
-				}
-			}
-			if isAuthEndpoint {
-				endpoint.AuthURL = endpointURL
-				isAuthEndpoint = false
-			}
-			if isTokenEndpoint {
-				endpoint.TokenURL = endpointURL
-				isAuthEndpoint = false
-			}
-
-			if endpoint.AuthURL != "" && endpoint.TokenURL != "" {
-				return endpoint, nil
-			}
-
-			tokenType = responseTokens.Next()
-			continue
-		}
-
-		tokenType = responseTokens.Next()
+	if err := c.ensureState(); err != nil {
+		return "", err
 	}
-	return Endpoint{}, errors.New("unable to find link header for `indieauth-metadata` or link headers for `rel=authorization_endpoint` and `rel=token_endpoint`")
-}
 
-func (c *Config) GetAuthorizationRequestURL() string {
-	verifier, _ := generateCodeVerifier()
+	verifier, err := generateCodeVerifier(c.randomReader())
+
+	if err != nil {
+		return "", err
+	}
 
 	c.Verifier = verifier
 	params := getHandshakeParams(*c)
+
+	return c.authRequestURL(params)
+}
+
+func (c *Config) authRequestURL(params url.Values) (string, error) {
 	u, err := url.Parse(c.Endpoint.AuthURL)
 
 	if err != nil {
-		// @TODO do something
-		fmt.Println(err.Error())
+		return "", err
 	}
 	u.RawQuery = params.Encode()
 
-	return u.String()
+	return u.String(), nil
+}
 
+// DeniedScopes returns the scopes that were requested but not granted with the current token.
+func (c *Config) DeniedScopes() Scopes {
+	return c.Token.Scope.Missing(c.Scopes)
 }
 
 func getHandshakeParams(c Config) url.Values {
@@ -181,24 +183,59 @@ func getHandshakeParams(c Config) url.Values {
 		"state":                 []string{c.State},
 		"code_challenge":        []string{codeChallenge},
 		"code_challenge_method": []string{"S256"},
-		"scope":                 []string{"profile email"},
+		"scope":                 []string{c.Scopes.String()},
 		"me":                    []string{c.Identifier.ProfileURL},
 	}
 
 	return request
 }
 
-func generateState(n int) (string, error) {
+// ensureState issues a new state when the previous one has been consumed, so a Config can start another login.
+func (c *Config) ensureState() error {
+	if c.State != "" {
+		return nil
+	}
+
+	state, err := c.newState()
+	if err != nil {
+		return err
+	}
+
+	c.State = state
+	c.StateIssued = timeNow()
+
+	return nil
+}
+
+// newState generates the state for an authorization request, using the generator from WithStateGenerator if set.
+func (c *Config) newState() (string, error) {
+	if c.stateGenerator == nil {
+		return generateState(c.randomReader(), stateLength)
+	}
+
+	state, err := c.stateGenerator()
+	if err != nil {
+		return "", err
+	}
+	if state == "" {
+		return "", errors.New("state generator returned an empty state")
+	}
+
+	return state, nil
+}
+
+// generateState reads n random bytes from r, encoded so the state can be sent in a URL unchanged.
+func generateState(r io.Reader, n int) (string, error) {
 	data := make([]byte, n)
-	if _, err := rand.Read(data); err != nil {
+	if _, err := io.ReadFull(r, data); err != nil {
 		return "", err
 	}
-	return base64.StdEncoding.EncodeToString(data), nil
+	return base64.RawURLEncoding.EncodeToString(data), nil
 }
 
-func generateCodeVerifier() (string, error) {
+func generateCodeVerifier(r io.Reader) (string, error) {
 	data := make([]byte, 32)
-	if _, err := rand.Read(data); err != nil {
+	if _, err := io.ReadFull(r, data); err != nil {
 		return "", err
 	}
 	return base64.RawURLEncoding.EncodeToString(data), nil
@@ -211,57 +248,151 @@ func s256CodeChallenge(s string) string {
 }
 
 func (c *Config) TokenExchange(state string, code string, iss string) (string, error) {
-	if c.State != state {
-		return "", errors.New("state value does not match")
-	}
+	return c.TokenExchangeContext(context.Background(), state, code, iss)
+}
+
+// TokenExchangeContext is like TokenExchange, but every request made to the authorization server is bound to ctx.
+func (c *Config) TokenExchangeContext(ctx context.Context, state string, code string, iss string) (string, error) {
+	err := c.verifyCallback(state, iss)
 
-	authURL, _ := url.QueryUnescape(iss)
+	if err != nil {
+		return "", err
+	}
 
-	if len(iss) > 0 && iss != authURL {
-		return "", errors.New("issuer value does not match does not match")
+	if c.Endpoint.TokenURL == "" {
+		return "", errors.New("authorization server does not have a token endpoint, only authentication is supported")
 	}
 
 	params := getTokenExchangeParams(*c, code)
 
-	tokenResponse, err := getTokenURLResponse(c.Endpoint.TokenURL, params)
+	tokenResponse, err := getTokenURLResponse(ctx, c.httpClient(), c.Endpoint.TokenURL, params)
 
 	if err != nil {
 		return "", err
 	}
 
-	profileURL, _ := url.QueryUnescape(tokenResponse.Me)
+	err = c.verifyMe(ctx, tokenResponse.Me)
 
-	if profileURL != c.Identifier.ProfileURL {
-		endpoints, err := discoveryAuthServer(profileURL)
-		if err != nil {
-			return "", err
+	if err != nil {
+		return "", err
+	}
+
+	c.setToken(tokenResponse)
+
+	// A response without a scope grants everything that was requested.
+	// https://www.rfc-editor.org/rfc/rfc6749#section-5.1
+	if tokenResponse.Scope == "" {
+		c.Token.Scope = c.Scopes
+	}
+
+	if tokenResponse.Profile != nil {
+		c.Identifier.Profile = *tokenResponse.Profile
+	}
+
+	return c.Token.AccessToken, nil
+}
+
+// verifyCallback checks the state and issuer the authorization server redirected back with, consuming the state
+// so the same response cannot be replayed.
+func (c *Config) verifyCallback(state string, iss string) error {
+	if c.State == "" {
+		if !c.StateIssued.IsZero() {
+			return ErrStateReused
 		}
-		if endpoints.AuthURL != c.Endpoint.AuthURL {
-			return "", errors.New(fmt.Sprintf("Auth Server responded with me value: %v. This did not match the Profile URL provided %v AND responded with a different authorization server,", endpoints.AuthURL, c.Endpoint.AuthURL))
+		return ErrStateUnknown
+	}
+
+	if subtle.ConstantTimeCompare([]byte(c.State), []byte(state)) != 1 {
+		return ErrStateMismatch
+	}
+
+	if c.stateExpired() {
+		return ErrStateExpired
+	}
+
+	if err := c.verifyIssuer(iss); err != nil {
+		return err
+	}
+
+	c.State = ""
+
+	return nil
+}
+
+// verifyIssuer checks the `iss` returned alongside the authorization code.
+func (c *Config) verifyIssuer(iss string) error {
+	// The issuer is compared with the one discovered from metadata, using simple string comparison.
+	// https://www.rfc-editor.org/rfc/rfc9207#section-2.4
+	if iss == "" {
+		if c.Endpoint.IssParameterSupported {
+			return fmt.Errorf("%w: authorization server %v did not return the required `iss` parameter", ErrIssuerMismatch, c.Endpoint.Issuer)
 		}
+		return nil
+	}
+
+	if c.Endpoint.Issuer != "" && iss != c.Endpoint.Issuer {
+		return fmt.Errorf("%w: expected %v, got %v", ErrIssuerMismatch, c.Endpoint.Issuer, iss)
+	}
+
+	return nil
+}
+
+// stateExpired reports whether the state was issued longer than StateExpiry ago. A state without an issue time,
+// such as one set by hand, does not expire.
+func (c *Config) stateExpired() bool {
+	if c.StateIssued.IsZero() {
+		return false
+	}
+
+	expiry := c.StateExpiry
+	if expiry == 0 {
+		expiry = DefaultStateExpiry
 	}
 
+	return !timeNow().Before(c.StateIssued.Add(expiry))
+}
+
+// verifyMe checks the `me` returned when redeeming an authorization code. When it differs from the profile URL
+// discovered, it must be a valid profile URL whose own authorization server is the one that issued the code, in
+// which case it becomes the user's profile URL.
+// https://indieauth.spec.indieweb.org/#authorization-server-confirmation
+func (c *Config) verifyMe(ctx context.Context, me string) error {
+	id, err := newUserIdentifier(me)
+
 	if err != nil {
-		return "", err
+		return &MeMismatchError{ProfileURL: c.Identifier.ProfileURL, Me: me, Err: err}
 	}
 
-	c.Token.AccessToken = tokenResponse.AccessToken
-	c.Token.Expires = tokenResponse.Expires
+	if id.ProfileURL == c.Identifier.ProfileURL {
+		return nil
+	}
 
-	if tokenResponse.RefreshToken != "" {
-		c.Token.RefreshToken = tokenResponse.RefreshToken
+	discovered, err := discover(ctx, c.httpClient(), id.ProfileURL)
+
+	if err != nil {
+		return &MeMismatchError{ProfileURL: c.Identifier.ProfileURL, Me: me, Err: err}
 	}
 
-	if tokenResponse.Scope != "" {
-		c.Token.Scope = strings.Split(tokenResponse.Scope, " ")
+	// Servers publishing metadata are identified by their issuer, otherwise by their authorization endpoint.
+	sameServer := discovered.Endpoint.AuthURL == c.Endpoint.AuthURL
+	if c.Endpoint.Issuer != "" || discovered.Endpoint.Issuer != "" {
+		sameServer = discovered.Endpoint.Issuer == c.Endpoint.Issuer
 	}
 
-	return c.Token.AccessToken, nil
+	if !sameServer {
+		err = fmt.Errorf("%v is authorized by %v, not %v", discovered.ProfileURL, discovered.Endpoint.AuthURL, c.Endpoint.AuthURL)
+		return &MeMismatchError{ProfileURL: c.Identifier.ProfileURL, Me: me, Err: err}
+	}
+
+	c.Identifier.ProfileURL = discovered.ProfileURL
+	c.Identifier.Links = discovered.Links
+
+	return nil
 }
 
-func getTokenURLResponse(u string, params url.Values) (TokenResponseParams, error) {
+func getTokenURLResponse(ctx context.Context, client *http.Client, u string, params url.Values) (TokenResponseParams, error) {
 
-	req, err := http.NewRequest("POST", u, strings.NewReader(params.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", u, strings.NewReader(params.Encode()))
 
 	if err != nil {
 		return TokenResponseParams{}, err
@@ -270,33 +401,87 @@ func getTokenURLResponse(u string, params url.Values) (TokenResponseParams, erro
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
 
-	//debug(httputil.DumpRequestOut(req, true))
-	resp, err := (&http.Client{}).Do(req)
+	resp, err := do(client, req)
 
-	debug(httputil.DumpResponse(resp, true))
 	if err != nil {
 		return TokenResponseParams{}, err
 	}
 	defer resp.Body.Close()
 
-	if r := resp.Header.Get("Content-Type"); r != "application/json" {
-		b, _ := io.ReadAll(resp.Body)
-		e := fmt.Sprintf("Application type was %v\n\nBody response: %v\n", r, string(b))
-		return TokenResponseParams{}, errors.New(e)
+	if resp.StatusCode != http.StatusOK {
+		return TokenResponseParams{}, newResponseError(resp)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return TokenResponseParams{}, errors.New(fmt.Sprintf("Received status code of %v when expected 201", resp.StatusCode))
+	tokenResponse, err := decodeTokenResponse(resp)
+
+	if err != nil {
+		return TokenResponseParams{}, err
 	}
 
-	tokenResponse := &TokenResponseParams{}
-	err = json.NewDecoder(resp.Body).Decode(tokenResponse)
+	// Legacy servers may omit the token type, but any other type than a bearer token can't be used.
+	if tokenResponse.AccessToken != "" && tokenResponse.TokenType != "" && !strings.EqualFold(tokenResponse.TokenType, "Bearer") {
+		return TokenResponseParams{}, fmt.Errorf("unsupported token_type %v, expected Bearer", tokenResponse.TokenType)
+	}
+
+	return tokenResponse, nil
+}
+
+// decodeTokenResponse reads a JSON response, or a form-encoded one as sent by legacy servers like
+// tokens.indieauth.com.
+func decodeTokenResponse(resp *http.Response) (TokenResponseParams, error) {
+	r := resp.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(r)
+
+	if err != nil {
+		return TokenResponseParams{}, fmt.Errorf("unable to parse Content-Type %q: %w", r, err)
+	}
+
+	tokenResponse := TokenResponseParams{}
+
+	switch mediaType {
+	case "application/json":
+		err = json.NewDecoder(resp.Body).Decode(&tokenResponse)
+	case "application/x-www-form-urlencoded":
+		var b []byte
+		var values url.Values
+		b, err = io.ReadAll(resp.Body)
+		if err == nil {
+			values, err = url.ParseQuery(string(b))
+		}
+		if err == nil {
+			tokenResponse, err = tokenResponseFromValues(values)
+		}
+	default:
+		// Only the start of the body is included, it could be an entire HTML page.
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		err = fmt.Errorf("unsupported media type %v in the token response from %v: %q", mediaType, resp.Request.URL, b)
+	}
 
 	if err != nil {
 		return TokenResponseParams{}, err
 	}
 
-	return *tokenResponse, nil
+	return tokenResponse, nil
+}
+
+func tokenResponseFromValues(values url.Values) (TokenResponseParams, error) {
+	tokenResponse := TokenResponseParams{
+		AccessToken:  values.Get("access_token"),
+		TokenType:    values.Get("token_type"),
+		Me:           values.Get("me"),
+		Scope:        values.Get("scope"),
+		RefreshToken: values.Get("refresh_token"),
+	}
+
+	if expires := values.Get("expires_in"); expires != "" {
+		seconds, err := strconv.Atoi(expires)
+		if err != nil {
+			return TokenResponseParams{}, fmt.Errorf("invalid expires_in %q: %w", expires, err)
+		}
+		tokenResponse.Expires = seconds
+	}
+
+	return tokenResponse, nil
 }
 
 func getTokenExchangeParams(c Config, code string) url.Values {
@@ -312,25 +497,3 @@ func getTokenExchangeParams(c Config, code string) url.Values {
 
 	return params
 }
-
-func debug(data []byte, err error) error {
-	if err == nil {
-		output := fmt.Sprintf("%s\n\n", data)
-		//f, err := os.Create("data.txt")
-		f, err := os.OpenFile("data.txt", os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-
-		_, err = f.WriteString(output)
-
-		if err != nil {
-			return err
-		}
-
-		return nil
-	} else {
-		return err
-	}
-}
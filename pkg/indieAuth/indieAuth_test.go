@@ -1,78 +1,88 @@
 package indieAuth
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
-func TestDiscoveryAuthServer(t *testing.T) {
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(`<a href="f00"> </a><link href="http://localhost/auth" rel="authorization_endpoint"><link href="http://localhost/token" rel="token_endpoint">`))
-	}))
-	defer ts.Close()
+func TestGenerateState(t *testing.T) {
+	c := Config{}
+	stateStr, err := c.newState()
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
 
-	type TestUrl struct {
-		sourceUrl        string
-		authEndpointUrl  string
-		tokenEndpointUrl string
+	// 128 bits, base64 encoded without padding.
+	expectedLength := 22
+	if len(stateStr) != expectedLength {
+		t.Errorf("Expected length of '%v', got '%v'", expectedLength, len(stateStr))
 	}
 
-	tests := []TestUrl{
-		{
-			sourceUrl:        ts.URL,
-			authEndpointUrl:  "http://localhost/auth",
-			tokenEndpointUrl: "http://localhost/token",
-		},
-		{
-			sourceUrl:        "https://zietlow.io/",
-			authEndpointUrl:  "https://indieauth.com/auth",
-			tokenEndpointUrl: "https://tokens.indieauth.com/token",
-		},
+	// The state must survive a round trip through a URL unescaped.
+	if url.QueryEscape(stateStr) != stateStr {
+		t.Errorf("Expected a URL safe state, got '%v'", stateStr)
 	}
 
-	for _, Url := range tests {
-		endpoint, err := discoveryAuthServer(Url.sourceUrl)
-		if err != nil {
-			t.Errorf("Unexpected error: %v", err)
-		}
-		if endpoint.AuthURL != Url.authEndpointUrl {
-			t.Errorf("Parsed %v Expected '%v', got '%s'", Url.sourceUrl, Url.authEndpointUrl, endpoint.AuthURL)
-		}
-		if endpoint.TokenURL != Url.tokenEndpointUrl {
-			t.Errorf("Parsed %v Expected '%v', got '%s'", Url.sourceUrl, Url.tokenEndpointUrl, endpoint.TokenURL)
-		}
+	c = Config{random: strings.NewReader(strings.Repeat("\xff", stateLength))}
+	if stateStr, err = c.newState(); err != nil || stateStr != "_____________________w" {
+		t.Errorf("newState() = %v, %v, want the state read from the random source", stateStr, err)
+	}
+
+	c = Config{random: strings.NewReader("short")}
+	if _, err := c.newState(); err == nil {
+		t.Errorf("Expected an error when the random source runs out")
 	}
 }
 
-func TestGenerateState(t *testing.T) {
-	n := 10
-	stateStr, err := generateState(n)
+func TestWithStateGenerator(t *testing.T) {
+	c := Config{}
+	WithStateGenerator(func() (string, error) { return "payload.signature", nil })(&c)
+
+	req, err := c.NewAuthorizationRequest(ScopeProfile)
 	if err != nil {
-		t.Errorf("Unexpected error: %v", err)
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if req.State != "payload.signature" {
+		t.Errorf("State = %v, want the generated state", req.State)
 	}
 
-	// Check for expected return encoded length due to base64 encoded.
-	expectedLength := ((n + 2) / 3) * 4
-	if len(stateStr) != expectedLength {
-		t.Errorf("Expected length of '%v', got '%v'", expectedLength, len(stateStr))
+	WithStateGenerator(func() (string, error) { return "", nil })(&c)
+	if _, err := c.NewAuthorizationRequest(ScopeProfile); err == nil {
+		t.Errorf("Expected an error for an empty state")
 	}
 }
 
 func TestNew(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<link rel="authorization_endpoint" href="https://indieauth.com/auth"><link rel="token_endpoint" href="https://tokens.indieauth.com/token"><a href="https://github.com/aczietlow" rel="me">Github</a>`))
+	}))
+	defer ts.Close()
+
+	// Profile URLs must be domains, so use localhost rather than the loopback address.
+	profileURL := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
 	// Define test cases
 	testCases := []struct {
 		name       string
 		profileURL string
+		opts       []Option
 		wantErr    bool
 		wantConfig Config
 	}{
 		{
 			name:       "Valid Profile URL",
-			profileURL: "https://zietlow.io",
+			profileURL: profileURL,
 			wantErr:    false,
 			wantConfig: Config{
 				ClientID: "http://localhost:9002/",
@@ -81,11 +91,26 @@ func TestNew(t *testing.T) {
 					TokenURL: "https://tokens.indieauth.com/token",
 				},
 				Identifier: Identifier{
-					ProfileURL: "https://zietlow.io/",
+					ProfileURL: profileURL + "/",
+					Links: Links{
+						"authorization_endpoint": []string{"https://indieauth.com/auth"},
+						"token_endpoint":         []string{"https://tokens.indieauth.com/token"},
+						"me":                     []string{"https://github.com/aczietlow"},
+					},
 				},
 				RedirectURL: "http://localhost:9002/redirect",
+				Scopes:      Scopes{ScopeProfile, ScopeEmail},
+				State:       "AAAAAAAAAAAAAAAAAAAAAA",
+				StateIssued: now,
 			},
 		},
+		{
+			name:       "Missing Client ID",
+			profileURL: profileURL,
+			opts:       []Option{WithClientID("")},
+			wantErr:    true,
+			wantConfig: Config{},
+		},
 		{
 			name:       "Invalid Profile URL",
 			profileURL: "invalid",
@@ -97,7 +122,13 @@ func TestNew(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			// Call the function with the test case parameters
-			config, err := New(tc.profileURL)
+			opts := append([]Option{
+				WithClientID("http://localhost:9002/"),
+				WithRedirectURL("http://localhost:9002/redirect"),
+				WithHTTPClient(ts.Client()),
+				WithRandom(strings.NewReader(strings.Repeat("\x00", stateLength))),
+			}, tc.opts...)
+			config, err := New(tc.profileURL, opts...)
 
 			// If we want an error and there isn't one, or vice versa, fail the test.
 			if (err != nil) != tc.wantErr {
@@ -105,9 +136,8 @@ func TestNew(t *testing.T) {
 				return
 			}
 
-			// @TODO: Remove hack
-			// Hack out state value as this is generated at run time.
-			config.State = ""
+			config.client = nil
+			config.random = nil
 
 			// Check the returned config
 			if !reflect.DeepEqual(config, tc.wantConfig) {
@@ -121,9 +151,10 @@ func TestGetTokenURLResponse(t *testing.T) {
 	// Mock HTTP server
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
+		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(TokenResponseParams{
 			AccessToken:  "test_access_token",
+			TokenType:    "Bearer",
 			Me:           "test_me",
 			Scope:        "test_scope",
 			Expires:      3600,
@@ -142,7 +173,7 @@ func TestGetTokenURLResponse(t *testing.T) {
 	}
 
 	// Call the function
-	resp, err := getTokenURLResponse(ts.URL, params)
+	resp, err := getTokenURLResponse(context.Background(), ts.Client(), ts.URL, params)
 
 	// Check for error
 	if err != nil {
@@ -166,3 +197,60 @@ func TestGetTokenURLResponse(t *testing.T) {
 		t.Errorf("Expected refresh token 'test_refresh_token', got '%s'", resp.RefreshToken)
 	}
 }
+
+func TestGetTokenURLResponseFormats(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		status      int
+		body        string
+		wantErr     bool
+	}{
+		{"JSON with charset", "application/json; charset=utf-8", http.StatusOK, `{"access_token": "test_access_token", "token_type": "Bearer", "me": "https://example.com/", "expires_in": 3600}`, false},
+		{"Form encoded", "application/x-www-form-urlencoded", http.StatusOK, `access_token=test_access_token&token_type=bearer&me=https%3A%2F%2Fexample.com%2F&expires_in=3600`, false},
+		{"Unsupported token type", "application/json", http.StatusOK, `{"access_token": "test_access_token", "token_type": "mac"}`, true},
+		{"Unsupported content type", "text/html", http.StatusOK, `<html></html>`, true},
+		{"Created", "application/json", http.StatusCreated, `{"access_token": "test_access_token", "token_type": "Bearer"}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer ts.Close()
+
+			resp, err := getTokenURLResponse(context.Background(), ts.Client(), ts.URL, url.Values{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getTokenURLResponse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if resp.AccessToken != "test_access_token" || resp.Me != "https://example.com/" || resp.Expires != 3600 {
+				t.Errorf("Unexpected token response %+v", resp)
+			}
+		})
+	}
+}
+
+func TestGetTokenURLResponseUnsupportedContentType(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html>\n" + strings.Repeat("<p>Sign in</p>", 1000) + "</html>"))
+	}))
+	defer ts.Close()
+
+	_, err := getTokenURLResponse(context.Background(), ts.Client(), ts.URL, url.Values{})
+	if err == nil {
+		t.Fatal("Expected an error for an unsupported content type")
+	}
+
+	msg := err.Error()
+	if !strings.HasPrefix(msg, "unsupported media type text/html ") || strings.Contains(msg, "\n") || len(msg) > 512 {
+		t.Errorf("Unexpected error message %q", msg)
+	}
+}
@@ -0,0 +1,136 @@
+package indieAuth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Introspection is the authorization server's view of an access token.
+// https://indieauth.spec.indieweb.org/#access-token-verification-response
+type Introspection struct {
+	Active   bool   `json:"active"`
+	Me       string `json:"me"`
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope"`
+	Exp      int64  `json:"exp"`
+	Iat      int64  `json:"iat"`
+}
+
+// Introspect asks the authorization server whether token is active. The introspection_endpoint advertised in the
+// server's metadata is used when available (RFC 7662); the authorization it requires is added by the function
+// supplied with WithIntrospectionAuth, or by the http.Client supplied with WithHTTPClient. Servers without metadata
+// are asked by presenting the token to the token endpoint, as in earlier versions of the spec.
+func (c *Config) Introspect(token string) (Introspection, error) {
+	return c.IntrospectContext(context.Background(), token)
+}
+
+// IntrospectContext is like Introspect, but the request to the authorization server is bound to ctx.
+func (c *Config) IntrospectContext(ctx context.Context, token string) (Introspection, error) {
+	if c.Endpoint.IntrospectionURL == "" {
+		return legacyIntrospect(ctx, c.httpClient(), c.Endpoint.TokenURL, token)
+	}
+
+	params := url.Values{
+		"token": []string{token},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.Endpoint.IntrospectionURL, strings.NewReader(params.Encode()))
+
+	if err != nil {
+		return Introspection{}, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if c.introspectionAuth != nil {
+		c.introspectionAuth(req)
+	}
+
+	resp, err := do(c.httpClient(), req)
+
+	if err != nil {
+		return Introspection{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Introspection{}, newResponseError(resp)
+	}
+
+	introspection := Introspection{}
+	err = json.NewDecoder(resp.Body).Decode(&introspection)
+
+	if err != nil {
+		return Introspection{}, err
+	}
+
+	return introspection, nil
+}
+
+// CanIntrospect reports whether Introspect can be authorized: the server has no introspection endpoint, so the
+// token itself is presented, or WithIntrospectionAuth was provided. Authorization added by an http.Client supplied
+// with WithHTTPClient cannot be detected.
+func (c *Config) CanIntrospect() bool {
+	if c.Endpoint.IntrospectionURL == "" {
+		return c.Endpoint.TokenURL != ""
+	}
+
+	return c.introspectionAuth != nil
+}
+
+// ActiveFor reports whether the token is active and was issued to profileURL, comparing `me` once normalized, so
+// `https://example.com` matches `https://example.com/`.
+func (i Introspection) ActiveFor(profileURL string) bool {
+	if !i.Active {
+		return false
+	}
+
+	id, err := newUserIdentifier(i.Me)
+	if err != nil {
+		return false
+	}
+
+	return id.ProfileURL == profileURL
+}
+
+// legacyIntrospect verifies token by presenting it to the token endpoint, which responds with the token's details
+// while it is still valid.
+func legacyIntrospect(ctx context.Context, client *http.Client, tokenURL string, token string) (Introspection, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", tokenURL, nil)
+
+	if err != nil {
+		return Introspection{}, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := do(client, req)
+
+	if err != nil {
+		return Introspection{}, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+		return Introspection{Active: false}, nil
+	default:
+		return Introspection{}, newResponseError(resp)
+	}
+
+	introspection := Introspection{}
+	err = json.NewDecoder(resp.Body).Decode(&introspection)
+
+	if err != nil {
+		return Introspection{}, err
+	}
+	introspection.Active = introspection.Me != ""
+
+	return introspection, nil
+}
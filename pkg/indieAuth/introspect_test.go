@@ -0,0 +1,91 @@
+package indieAuth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestIntrospect(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/introspect":
+			r.ParseForm()
+			if r.Method != "POST" || r.PostForm.Get("token") != "valid_token" {
+				w.Write([]byte(`{"active": false}`))
+				return
+			}
+			w.Write([]byte(`{"active": true, "me": "https://example.com/", "client_id": "http://localhost:9002/", "scope": "create", "exp": 1717243200, "iat": 1717239600}`))
+		case "/token":
+			if r.Method != "GET" || r.Header.Get("Authorization") != "Bearer valid_token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"me": "https://example.com/", "client_id": "http://localhost:9002/", "scope": "create"}`))
+		}
+	}))
+	defer ts.Close()
+
+	metadata := Config{Endpoint: Endpoint{TokenURL: ts.URL + "/token", IntrospectionURL: ts.URL + "/introspect"}, client: ts.Client()}
+	legacy := Config{Endpoint: Endpoint{TokenURL: ts.URL + "/token"}, client: ts.Client()}
+
+	tests := []struct {
+		name   string
+		config Config
+		token  string
+		want   Introspection
+	}{
+		{"Introspection endpoint active", metadata, "valid_token", Introspection{Active: true, Me: "https://example.com/", ClientID: "http://localhost:9002/", Scope: "create", Exp: 1717243200, Iat: 1717239600}},
+		{"Introspection endpoint inactive", metadata, "revoked_token", Introspection{}},
+		{"Token endpoint active", legacy, "valid_token", Introspection{Active: true, Me: "https://example.com/", ClientID: "http://localhost:9002/", Scope: "create"}},
+		{"Token endpoint inactive", legacy, "revoked_token", Introspection{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.config.Introspect(tt.token)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Introspect() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntrospectAuth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer app_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"active": true, "me": "https://example.com"}`))
+	}))
+	defer ts.Close()
+
+	c := Config{Endpoint: Endpoint{TokenURL: ts.URL, IntrospectionURL: ts.URL}, client: ts.Client()}
+	if c.CanIntrospect() {
+		t.Errorf("Expected an introspection endpoint without authorization to be unusable")
+	}
+	if _, err := c.Introspect("valid_token"); err == nil {
+		t.Errorf("Expected an error without authorization")
+	}
+
+	WithIntrospectionAuth(func(r *http.Request) { r.Header.Set("Authorization", "Bearer app_token") })(&c)
+	if !c.CanIntrospect() {
+		t.Errorf("Expected introspection to be authorized")
+	}
+
+	got, err := c.Introspect("valid_token")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !got.ActiveFor("https://example.com/") {
+		t.Errorf("Expected %+v to be active for https://example.com/", got)
+	}
+	if got.ActiveFor("https://other.example.com/") {
+		t.Errorf("Expected %+v not to be active for https://other.example.com/", got)
+	}
+}
@@ -0,0 +1,123 @@
+package indieAuth
+
+import (
+	"crypto/rand"
+	"io"
+	"net/http"
+	"time"
+)
+
+const userAgent = "go-indieauth-client"
+
+// stateLength is the number of random bytes in a generated state, giving 128 bits of entropy.
+const stateLength = 16
+
+// DefaultStateExpiry is how long a login may take, from building the authorization request to the user returning
+// with the authorization code, unless WithStateExpiry is provided.
+const DefaultStateExpiry = 10 * time.Minute
+
+// DefaultHTTPClient is used for every outbound request unless WithHTTPClient is provided.
+var DefaultHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// Option configures a Config built by New.
+type Option func(*Config)
+
+// WithClientID sets the client identifier, the URL of the application requesting authorization.
+func WithClientID(clientID string) Option {
+	return func(c *Config) {
+		c.ClientID = clientID
+	}
+}
+
+// WithRedirectURL sets the URL the authorization server redirects the user back to.
+func WithRedirectURL(redirectURL string) Option {
+	return func(c *Config) {
+		c.RedirectURL = redirectURL
+	}
+}
+
+// WithScopes sets the scopes requested in the authorization request. Defaults to "profile email".
+func WithScopes(scopes ...Scope) Option {
+	return func(c *Config) {
+		c.Scopes = scopes
+	}
+}
+
+// WithConf sets the client identifier and redirect URL from a Conf, such as one read by LoadConfig.
+func WithConf(conf *Conf) Option {
+	return func(c *Config) {
+		c.ClientID = conf.URL
+		c.RedirectURL = conf.RedirectURL
+	}
+}
+
+// WithStateExpiry sets how long the state sent in an authorization request is accepted for.
+func WithStateExpiry(expiry time.Duration) Option {
+	return func(c *Config) {
+		c.StateExpiry = expiry
+	}
+}
+
+// WithHTTPClient sets the http.Client used for discovery and for every request to the authorization server,
+// allowing callers to supply their own timeouts, proxies, TLS roots or instrumentation.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Config) {
+		c.client = client
+	}
+}
+
+// WithStore sets where NewAuthorizationRequest saves each login in progress, for TakeAuthorizationRequest to find
+// again when the user returns.
+func WithStore(s Store) Option {
+	return func(c *Config) {
+		c.store = s
+	}
+}
+
+// WithIntrospectionAuth sets a function adding the authorization required by the introspection endpoint of a
+// server publishing metadata, such as a bearer token issued to the application, to each introspection request.
+func WithIntrospectionAuth(authorize func(*http.Request)) Option {
+	return func(c *Config) {
+		c.introspectionAuth = authorize
+	}
+}
+
+// WithStateGenerator replaces the random state sent in each authorization request, for instance to embed a signed
+// payload such as the page to return the user to. The state must still be unguessable, as it protects the
+// redirect URL from forged requests.
+func WithStateGenerator(generate func() (string, error)) Option {
+	return func(c *Config) {
+		c.stateGenerator = generate
+	}
+}
+
+// WithRandom sets the source of the random bytes in each state and code verifier, which defaults to crypto/rand.
+// It is intended for deterministic tests, anything else weakens the security of the flow.
+func WithRandom(r io.Reader) Option {
+	return func(c *Config) {
+		c.random = r
+	}
+}
+
+func (c *Config) randomReader() io.Reader {
+	if c.random == nil {
+		return rand.Reader
+	}
+	return c.random
+}
+
+func (c *Config) httpClient() *http.Client {
+	if c.client == nil {
+		return DefaultHTTPClient
+	}
+	return c.client
+}
+
+// do sends req with the package's User-Agent, unless the caller has already set one.
+func do(client *http.Client, req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+
+	return client.Do(req)
+}
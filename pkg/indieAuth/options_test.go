@@ -0,0 +1,34 @@
+package indieAuth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithHTTPClient(t *testing.T) {
+	var gotUserAgent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUserAgent = r.Header.Get("User-Agent")
+		w.Write([]byte(`<link href="http://localhost/auth" rel="authorization_endpoint"><link href="http://localhost/token" rel="token_endpoint">`))
+	}))
+	defer ts.Close()
+
+	c := Config{}
+	if c.httpClient() != DefaultHTTPClient {
+		t.Errorf("Expected the default client when none is provided")
+	}
+
+	WithHTTPClient(ts.Client())(&c)
+	if c.httpClient() != ts.Client() {
+		t.Errorf("Expected the provided client to be used")
+	}
+
+	if _, err := discover(context.Background(), c.httpClient(), ts.URL); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if gotUserAgent != userAgent {
+		t.Errorf("Expected User-Agent '%v', got '%v'", userAgent, gotUserAgent)
+	}
+}
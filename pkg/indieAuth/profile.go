@@ -0,0 +1,64 @@
+package indieAuth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Profile is the user's profile information, returned when the `profile` and `email` scopes are granted.
+// https://indieauth.spec.indieweb.org/#profile-information
+type Profile struct {
+	Name  string `json:"name"`
+	URL   string `json:"url"`
+	Photo string `json:"photo"`
+	Email string `json:"email,omitempty"`
+}
+
+// UserInfo fetches the user's profile from the userinfo_endpoint advertised in the server's metadata, using the
+// access token, and stores it on c.Identifier.
+func (c *Config) UserInfo() (Profile, error) {
+	return c.UserInfoContext(context.Background())
+}
+
+// UserInfoContext is like UserInfo, but the request to the authorization server is bound to ctx.
+func (c *Config) UserInfoContext(ctx context.Context) (Profile, error) {
+	if c.Endpoint.UserinfoURL == "" {
+		return Profile{}, errors.New("authorization server does not advertise a `userinfo_endpoint`")
+	}
+
+	if c.Token.AccessToken == "" {
+		return Profile{}, errors.New("no access token available")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.Endpoint.UserinfoURL, nil)
+
+	if err != nil {
+		return Profile{}, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.Token.AccessToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := do(c.httpClient(), req)
+
+	if err != nil {
+		return Profile{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Profile{}, newResponseError(resp)
+	}
+
+	profile := Profile{}
+	err = json.NewDecoder(resp.Body).Decode(&profile)
+
+	if err != nil {
+		return Profile{}, err
+	}
+	c.Identifier.Profile = profile
+
+	return profile, nil
+}
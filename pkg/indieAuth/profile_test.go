@@ -0,0 +1,47 @@
+package indieAuth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUserInfo(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"name": "Example User", "url": "https://example.com/", "photo": "https://example.com/photo.jpg", "email": "user@example.com"}`))
+	}))
+	defer ts.Close()
+
+	c := Config{
+		Endpoint: Endpoint{UserinfoURL: ts.URL},
+		Token:    Token{AccessToken: "access_token"},
+		client:   ts.Client(),
+	}
+
+	profile, err := c.UserInfo()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := Profile{Name: "Example User", URL: "https://example.com/", Photo: "https://example.com/photo.jpg", Email: "user@example.com"}
+	if profile != want {
+		t.Errorf("UserInfo() = %v, want %v", profile, want)
+	}
+	if c.Identifier.Profile != want {
+		t.Errorf("Expected the profile to be stored on the identifier, got %v", c.Identifier.Profile)
+	}
+
+	c.Token.AccessToken = "revoked_token"
+	if _, err := c.UserInfo(); err == nil {
+		t.Errorf("Expected an error for a rejected access token")
+	}
+
+	c.Endpoint.UserinfoURL = ""
+	if _, err := c.UserInfo(); err == nil {
+		t.Errorf("Expected an error without a userinfo endpoint")
+	}
+}
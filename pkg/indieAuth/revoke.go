@@ -0,0 +1,50 @@
+package indieAuth
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Revoke asks the authorization server to invalidate token. The revocation_endpoint advertised in the server's
+// metadata is used when available (RFC 7009), otherwise the token endpoint's legacy `action=revoke` request.
+func (c *Config) Revoke(token string) error {
+	return c.RevokeContext(context.Background(), token)
+}
+
+// RevokeContext is like Revoke, but the request to the authorization server is bound to ctx.
+func (c *Config) RevokeContext(ctx context.Context, token string) error {
+	revocationURL := c.Endpoint.RevocationURL
+	params := url.Values{
+		"token": []string{token},
+	}
+
+	if revocationURL == "" {
+		revocationURL = c.Endpoint.TokenURL
+		params.Set("action", "revoke")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", revocationURL, strings.NewReader(params.Encode()))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := do(c.httpClient(), req)
+
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Servers respond with 200 even when the token was already invalid.
+	if resp.StatusCode != http.StatusOK {
+		return newResponseError(resp)
+	}
+
+	return nil
+}
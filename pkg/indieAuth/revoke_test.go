@@ -0,0 +1,68 @@
+package indieAuth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestRevoke(t *testing.T) {
+	var gotPath string
+	var gotParams url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		gotPath = r.URL.Path
+		gotParams = r.PostForm
+		if r.PostForm.Get("token") == "unrevokable_token" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		endpoint   Endpoint
+		token      string
+		wantPath   string
+		wantParams url.Values
+		wantErr    bool
+	}{
+		{
+			name:       "Revocation endpoint",
+			endpoint:   Endpoint{TokenURL: ts.URL + "/token", RevocationURL: ts.URL + "/revoke"},
+			token:      "access_token",
+			wantPath:   "/revoke",
+			wantParams: url.Values{"token": []string{"access_token"}},
+		},
+		{
+			name:       "Token endpoint",
+			endpoint:   Endpoint{TokenURL: ts.URL + "/token"},
+			token:      "access_token",
+			wantPath:   "/token",
+			wantParams: url.Values{"token": []string{"access_token"}, "action": []string{"revoke"}},
+		},
+		{
+			name:       "Server error",
+			endpoint:   Endpoint{TokenURL: ts.URL + "/token", RevocationURL: ts.URL + "/revoke"},
+			token:      "unrevokable_token",
+			wantPath:   "/revoke",
+			wantParams: url.Values{"token": []string{"unrevokable_token"}},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Config{Endpoint: tt.endpoint, client: ts.Client()}
+			err := c.Revoke(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Revoke() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if gotPath != tt.wantPath || !reflect.DeepEqual(gotParams, tt.wantParams) {
+				t.Errorf("Revoke() sent %v %v, want %v %v", gotPath, gotParams, tt.wantPath, tt.wantParams)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package indieAuth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Scope is a permission requested from, and granted by, the authorization server.
+// https://indieauth.spec.indieweb.org/#authorization-request
+type Scope string
+
+const (
+	ScopeProfile  Scope = "profile"
+	ScopeEmail    Scope = "email"
+	ScopeCreate   Scope = "create"
+	ScopeUpdate   Scope = "update"
+	ScopeDelete   Scope = "delete"
+	ScopeMedia    Scope = "media"
+	ScopeRead     Scope = "read"
+	ScopeFollow   Scope = "follow"
+	ScopeChannels Scope = "channels"
+)
+
+// Scopes is a set of scopes, sent and received as a space-separated list.
+type Scopes []Scope
+
+// ParseScopes splits a space-separated scope parameter.
+func ParseScopes(s string) Scopes {
+	var scopes Scopes
+	for _, scope := range strings.Fields(s) {
+		scopes = append(scopes, Scope(scope))
+	}
+
+	return scopes
+}
+
+func (s Scopes) String() string {
+	scopes := make([]string, len(s))
+	for i, scope := range s {
+		scopes[i] = string(scope)
+	}
+
+	return strings.Join(scopes, " ")
+}
+
+// Contains reports whether scope is in the set.
+func (s Scopes) Contains(scope Scope) bool {
+	for _, v := range s {
+		if v == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Missing returns the scopes in want that are not in the set.
+func (s Scopes) Missing(want Scopes) Scopes {
+	var missing Scopes
+	for _, scope := range want {
+		if !s.Contains(scope) {
+			missing = append(missing, scope)
+		}
+	}
+
+	return missing
+}
+
+// Validate checks the scopes can be requested from a server advertising supported in its `scopes_supported`
+// metadata. Servers that don't advertise their scopes accept any.
+func (s Scopes) Validate(supported []string) error {
+	if s.Contains(ScopeEmail) && !s.Contains(ScopeProfile) {
+		return errors.New("the `email` scope cannot be requested without the `profile` scope")
+	}
+
+	if len(supported) == 0 {
+		return nil
+	}
+
+	unsupported := ParseScopes(strings.Join(supported, " ")).Missing(s)
+	if len(unsupported) > 0 {
+		return fmt.Errorf("authorization server does not support the scopes: %v", unsupported)
+	}
+
+	return nil
+}
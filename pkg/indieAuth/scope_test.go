@@ -0,0 +1,116 @@
+package indieAuth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseScopes(t *testing.T) {
+	scopes := ParseScopes(" profile  create update ")
+
+	want := Scopes{ScopeProfile, ScopeCreate, ScopeUpdate}
+	if !reflect.DeepEqual(scopes, want) {
+		t.Errorf("ParseScopes() = %v, want %v", scopes, want)
+	}
+	if scopes.String() != "profile create update" {
+		t.Errorf("String() = '%v', want 'profile create update'", scopes.String())
+	}
+
+	missing := scopes.Missing(Scopes{ScopeProfile, ScopeEmail, ScopeCreate, ScopeMedia})
+	if !reflect.DeepEqual(missing, Scopes{ScopeEmail, ScopeMedia}) {
+		t.Errorf("Missing() = %v, want %v", missing, Scopes{ScopeEmail, ScopeMedia})
+	}
+}
+
+func TestScopesValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		scopes    Scopes
+		supported []string
+		valid     bool
+	}{
+		{"No metadata", Scopes{ScopeCreate, "custom"}, nil, true},
+		{"Supported", Scopes{ScopeProfile, ScopeCreate}, []string{"profile", "email", "create"}, true},
+		{"Unsupported", Scopes{ScopeProfile, ScopeMedia}, []string{"profile", "email", "create"}, false},
+		{"Email without profile", Scopes{ScopeEmail}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.scopes.Validate(tt.supported)
+			if (err != nil) == tt.valid {
+				t.Errorf("Validate() error = %v, valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestGetAuthorizationRequestURLScopes(t *testing.T) {
+	c := Config{
+		Endpoint: Endpoint{AuthURL: "https://example.com/auth", ScopesSupported: []string{"profile", "create"}},
+		Scopes:   Scopes{ScopeProfile, ScopeEmail},
+	}
+
+	if _, err := c.GetAuthorizationRequestURL(); err == nil {
+		t.Errorf("Expected an error requesting a scope the server does not support")
+	}
+
+	if _, err := c.GetAuthorizationRequestURL(ScopeProfile, ScopeCreate); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	c.Token.Scope = ParseScopes("profile")
+	if denied := c.DeniedScopes(); !reflect.DeepEqual(denied, Scopes{ScopeCreate}) {
+		t.Errorf("DeniedScopes() = %v, want %v", denied, Scopes{ScopeCreate})
+	}
+}
+
+func TestTokenExchangeScope(t *testing.T) {
+	scope := ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TokenResponseParams{
+			AccessToken: "access_token",
+			TokenType:   "Bearer",
+			Me:          "https://example.com/",
+			Scope:       scope,
+		})
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		scope      string
+		wantScope  Scopes
+		wantDenied Scopes
+	}{
+		{"Scope omitted", "", Scopes{ScopeProfile, ScopeCreate}, nil},
+		{"Scope narrowed", "profile", Scopes{ScopeProfile}, Scopes{ScopeCreate}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope = tt.scope
+			c := Config{
+				Endpoint:   Endpoint{TokenURL: ts.URL},
+				Identifier: Identifier{ProfileURL: "https://example.com/"},
+				Scopes:     Scopes{ScopeProfile, ScopeCreate},
+				State:      "state",
+				client:     ts.Client(),
+			}
+
+			if _, err := c.TokenExchange("state", "code", ""); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(c.Token.Scope, tt.wantScope) {
+				t.Errorf("Token.Scope = %v, want %v", c.Token.Scope, tt.wantScope)
+			}
+			if denied := c.DeniedScopes(); !reflect.DeepEqual(denied, tt.wantDenied) {
+				t.Errorf("DeniedScopes() = %v, want %v", denied, tt.wantDenied)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package indieAuth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned by a Store when nothing has been saved under a key, or it has expired.
+	ErrNotFound = errors.New("not found in store")
+	// ErrTaken is returned by Store.Take when the key has already been taken.
+	ErrTaken = errors.New("already taken from store")
+)
+
+// Store persists a Config between requests: its pending authorization state while the user is at the
+// authorization server, and the tokens issued to it afterwards. The http.Client set by WithHTTPClient, and the
+// WithStore, WithRandom, WithStateGenerator and WithIntrospectionAuth options, are not persisted.
+type Store interface {
+	Save(key string, c Config) error
+	Load(key string) (Config, error)
+	Delete(key string) error
+	// Take loads and removes the Config saved under key in a single step, so only one caller ever receives it.
+	// Taking the key again returns ErrTaken until the key would have expired, or for DefaultStateExpiry in a
+	// store that keeps keys until deleted, after which it returns ErrNotFound.
+	Take(key string) (Config, error)
+}
+
+// MemoryStore is a Store safe for concurrent use that forgets each Config ttl after it was last saved.
+type MemoryStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	config  Config
+	expires time.Time
+	// taken entries are kept without their Config, so taking them again can be told apart from never saving them.
+	taken bool
+}
+
+// NewMemoryStore returns a MemoryStore evicting entries after ttl. A zero ttl keeps entries until deleted.
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{
+		ttl:     ttl,
+		entries: make(map[string]memoryEntry),
+	}
+}
+
+func (s *MemoryStore) Save(key string, c Config) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evict()
+
+	entry := memoryEntry{config: c}
+	if s.ttl > 0 {
+		entry.expires = timeNow().Add(s.ttl)
+	}
+	s.entries[key] = entry
+
+	return nil
+}
+
+func (s *MemoryStore) Load(key string) (Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evict()
+
+	entry, ok := s.entries[key]
+	if !ok || entry.taken {
+		return Config{}, ErrNotFound
+	}
+
+	return entry.config, nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+
+	return nil
+}
+
+func (s *MemoryStore) Take(key string) (Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evict()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return Config{}, ErrNotFound
+	}
+	if entry.taken {
+		return Config{}, ErrTaken
+	}
+
+	expires := entry.expires
+	if expires.IsZero() {
+		expires = timeNow().Add(DefaultStateExpiry)
+	}
+	s.entries[key] = memoryEntry{expires: expires, taken: true}
+
+	return entry.config, nil
+}
+
+// evict removes expired entries. s.mu must be held.
+func (s *MemoryStore) evict() {
+	now := timeNow()
+	for key, entry := range s.entries {
+		if !entry.expires.IsZero() && !now.Before(entry.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// FileStore is a Store keeping each Config as a JSON file in a directory, so logins survive a restart. Like
+// MemoryStore, it forgets each Config ttl after it was last saved, going by the file's modification time.
+type FileStore struct {
+	mu  sync.Mutex
+	dir string
+	ttl time.Duration
+}
+
+// NewFileStore returns a FileStore writing to dir, creating it if needed, and evicting files after ttl. A zero ttl
+// keeps files until deleted.
+func NewFileStore(dir string, ttl time.Duration) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &FileStore{dir: dir, ttl: ttl}, nil
+}
+
+func (s *FileStore) Save(key string, c Config) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.evict(); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so a crash never leaves a partially written Config behind.
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), s.path(key))
+}
+
+func (s *FileStore) Load(key string) (Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.evict(); err != nil {
+		return Config{}, err
+	}
+
+	return s.read(s.path(key))
+}
+
+func (s *FileStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, path := range []string{s.path(key), s.takenPath(key)} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Take renames the file before reading it. The rename is atomic, so even across processes sharing the directory
+// only one caller succeeds, and the renamed file is left behind, emptied, to recognise the key being taken again.
+func (s *FileStore) Take(key string) (Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.evict(); err != nil {
+		return Config{}, err
+	}
+
+	taken := s.takenPath(key)
+	info, err := os.Stat(s.path(key))
+	if err == nil {
+		err = os.Rename(s.path(key), taken)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(taken); err == nil {
+			return Config{}, ErrTaken
+		}
+		return Config{}, ErrNotFound
+	}
+	if err != nil {
+		return Config{}, err
+	}
+
+	c, err := s.read(taken)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if err := os.Truncate(taken, 0); err != nil {
+		return Config{}, err
+	}
+
+	// Keep the modification time of the Config, so the marker expires when the key would have.
+	modified := info.ModTime()
+	if s.ttl == 0 {
+		modified = timeNow()
+	}
+
+	return c, os.Chtimes(taken, modified, modified)
+}
+
+// evict removes expired files. Markers left by Take expire with the Config they replaced, or after
+// DefaultStateExpiry when the store keeps files until deleted. s.mu must be held.
+func (s *FileStore) evict() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	now := timeNow()
+	for _, entry := range entries {
+		ttl := s.ttl
+		switch filepath.Ext(entry.Name()) {
+		case ".json":
+		case ".taken":
+			if ttl == 0 {
+				ttl = DefaultStateExpiry
+			}
+		default:
+			continue
+		}
+		if ttl == 0 {
+			continue
+		}
+
+		info, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if !now.Before(info.ModTime().Add(ttl)) {
+			if err := os.Remove(filepath.Join(s.dir, entry.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *FileStore) read(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Config{}, ErrNotFound
+	}
+	if err != nil {
+		return Config{}, err
+	}
+
+	c := Config{}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return Config{}, err
+	}
+
+	return c, nil
+}
+
+// path hashes key, as keys such as profile URLs aren't valid file names.
+func (s *FileStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (s *FileStore) takenPath(key string) string {
+	return strings.TrimSuffix(s.path(key), ".json") + ".taken"
+}
@@ -0,0 +1,145 @@
+package indieAuth
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testStore(t *testing.T, s Store) {
+	c := Config{
+		ClientID:   "http://localhost:9002/",
+		Endpoint:   Endpoint{AuthURL: "https://example.com/auth", TokenURL: "https://example.com/token"},
+		Identifier: Identifier{ProfileURL: "https://example.com/", Links: Links{"me": []string{"https://github.com/example"}}},
+		Scopes:     Scopes{ScopeProfile, ScopeCreate},
+		State:      "state",
+		Verifier:   "verifier",
+		Token: Token{
+			AccessToken: "access_token",
+			Expires:     3600,
+			Expiry:      time.Date(2024, 6, 1, 13, 0, 0, 0, time.UTC),
+			Scope:       Scopes{ScopeCreate},
+		},
+	}
+
+	if _, err := s.Load("https://example.com/"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	if err := s.Save("https://example.com/", c); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	got, err := s.Load("https://example.com/")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, c) {
+		t.Errorf("Load() = %+v, want %+v", got, c)
+	}
+
+	if err := s.Save("state", c); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, err := s.Take("state"); err != nil || !reflect.DeepEqual(got, c) {
+		t.Errorf("Take() = %+v, %v, want %+v", got, err, c)
+	}
+	if _, err := s.Take("state"); !errors.Is(err, ErrTaken) {
+		t.Errorf("Expected ErrTaken, got %v", err)
+	}
+	if _, err := s.Load("state"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after Take, got %v", err)
+	}
+	if _, err := s.Take("unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	if err := s.Delete("https://example.com/"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := s.Load("https://example.com/"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after Delete, got %v", err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore(time.Hour))
+
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	s := NewMemoryStore(time.Minute)
+	if err := s.Save("key", Config{State: "state"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := s.Load("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected an expired entry to be evicted, got %v", err)
+	}
+
+	testTakenExpiry(t, NewMemoryStore(0), &now)
+}
+
+func TestFileStore(t *testing.T) {
+	s, err := NewFileStore(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, s)
+
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	if err := s.Save("key", Config{State: "state"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := s.Save("taken", Config{State: "state"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := s.Take("taken"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := s.Load("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected an expired file to be evicted, got %v", err)
+	}
+	if _, err := s.Take("taken"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the taken marker to expire with its file, got %v", err)
+	}
+	if files, _ := os.ReadDir(s.dir); len(files) != 0 {
+		t.Errorf("Expected every expired file to be removed, found %v", files)
+	}
+
+	s, err = NewFileStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testTakenExpiry(t, s, &now)
+}
+
+// testTakenExpiry checks a store keeping keys until deleted still forgets they were taken after DefaultStateExpiry.
+func testTakenExpiry(t *testing.T, s Store, now *time.Time) {
+	if err := s.Save("key", Config{State: "state"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := s.Take("key"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	*now = now.Add(DefaultStateExpiry - time.Second)
+	if _, err := s.Take("key"); !errors.Is(err, ErrTaken) {
+		t.Errorf("Expected ErrTaken, got %v", err)
+	}
+
+	*now = now.Add(time.Second)
+	if _, err := s.Take("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the taken marker to expire, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package indieAuth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// expiryDelta is how long before its expiry a token is refreshed by a TokenSource.
+const expiryDelta = 10 * time.Second
+
+// timeNow is replaced in tests.
+var timeNow = time.Now
+
+// IsExpired reports whether the token's expiry has passed. Tokens without an expiry never expire.
+func (t Token) IsExpired() bool {
+	return t.ExpiresWithin(0)
+}
+
+// ExpiresWithin reports whether the token expires within d from now.
+func (t Token) ExpiresWithin(d time.Duration) bool {
+	if t.Expiry.IsZero() {
+		return false
+	}
+	return !timeNow().Add(d).Before(t.Expiry)
+}
+
+// TokenSource supplies a valid access token.
+type TokenSource interface {
+	Token() (Token, error)
+}
+
+// TokenSource returns a TokenSource that refreshes c.Token shortly before it expires, when a refresh token is
+// available. Refreshed tokens are written back to c, so c must not be used concurrently with the source.
+func (c *Config) TokenSource(ctx context.Context) TokenSource {
+	return &refreshingTokenSource{ctx: ctx, c: c}
+}
+
+type refreshingTokenSource struct {
+	ctx context.Context
+	mu  sync.Mutex
+	c   *Config
+}
+
+func (s *refreshingTokenSource) Token() (Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.c.Token.AccessToken == "" {
+		return Token{}, errors.New("no access token available")
+	}
+
+	if s.c.Token.ExpiresWithin(expiryDelta) && s.c.Token.RefreshToken != "" {
+		if _, err := s.c.RefreshContext(s.ctx); err != nil {
+			return Token{}, err
+		}
+	}
+
+	if s.c.Token.IsExpired() {
+		return Token{}, errors.New("access token has expired and there is no refresh token")
+	}
+
+	return s.c.Token, nil
+}
+
+// Refresh exchanges the refresh token for a new access token, optionally narrowing the scopes granted.
+// https://indieauth.spec.indieweb.org/#refresh-tokens
+func (c *Config) Refresh(scopes ...Scope) (string, error) {
+	return c.RefreshContext(context.Background(), scopes...)
+}
+
+// RefreshContext is like Refresh, but the request to the token endpoint is bound to ctx.
+func (c *Config) RefreshContext(ctx context.Context, scopes ...Scope) (string, error) {
+	if c.Token.RefreshToken == "" {
+		return "", errors.New("no refresh token available")
+	}
+
+	params := getRefreshParams(*c, scopes)
+
+	tokenResponse, err := getTokenURLResponse(ctx, c.httpClient(), c.Endpoint.TokenURL, params)
+
+	if err != nil {
+		return "", err
+	}
+
+	if tokenResponse.AccessToken == "" {
+		return "", errors.New("token endpoint did not return an access token")
+	}
+
+	// `me` is compared once normalized, as verifyMe does, so `https://example.com` matches `https://example.com/`.
+	if tokenResponse.Me != "" {
+		id, err := newUserIdentifier(tokenResponse.Me)
+		if err != nil {
+			return "", &MeMismatchError{ProfileURL: c.Identifier.ProfileURL, Me: tokenResponse.Me, Err: err}
+		}
+
+		if id.ProfileURL != c.Identifier.ProfileURL {
+			err = errors.New("token endpoint refreshed a token for a different user")
+			return "", &MeMismatchError{ProfileURL: c.Identifier.ProfileURL, Me: tokenResponse.Me, Err: err}
+		}
+	}
+
+	c.setToken(tokenResponse)
+
+	// Narrowed scopes are granted when the response omits the scope, otherwise the previous scopes are kept.
+	// https://www.rfc-editor.org/rfc/rfc6749#section-5.1
+	if len(scopes) > 0 && tokenResponse.Scope == "" {
+		c.Token.Scope = scopes
+	}
+
+	return c.Token.AccessToken, nil
+}
+
+func getRefreshParams(c Config, scopes Scopes) url.Values {
+	params := url.Values{
+		"grant_type":    []string{"refresh_token"},
+		"refresh_token": []string{c.Token.RefreshToken},
+		"client_id":     []string{c.ClientID},
+	}
+
+	if len(scopes) > 0 {
+		params.Set("scope", scopes.String())
+	}
+
+	return params
+}
+
+// setToken stores a token endpoint response, keeping the previous refresh token and scopes unless new ones were issued.
+func (c *Config) setToken(tokenResponse TokenResponseParams) {
+	c.Token.AccessToken = tokenResponse.AccessToken
+	c.Token.Expires = tokenResponse.Expires
+	c.Token.Expiry = time.Time{}
+
+	if tokenResponse.Expires > 0 {
+		c.Token.Expiry = timeNow().Add(time.Duration(tokenResponse.Expires) * time.Second)
+	}
+
+	if tokenResponse.RefreshToken != "" {
+		c.Token.RefreshToken = tokenResponse.RefreshToken
+	}
+
+	if tokenResponse.Scope != "" {
+		c.Token.Scope = ParseScopes(tokenResponse.Scope)
+	}
+}
@@ -0,0 +1,182 @@
+package indieAuth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRefresh(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	var got url.Values
+	me := "https://example.com/"
+	scope := "create"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		got = r.PostForm
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TokenResponseParams{
+			AccessToken:  "new_access_token",
+			Me:           me,
+			Scope:        scope,
+			Expires:      3600,
+			RefreshToken: "new_refresh_token",
+		})
+	}))
+	defer ts.Close()
+
+	c := Config{
+		ClientID:   "http://localhost:9002/",
+		Endpoint:   Endpoint{TokenURL: ts.URL},
+		Identifier: Identifier{ProfileURL: "https://example.com/"},
+		Token: Token{
+			AccessToken:  "old_access_token",
+			RefreshToken: "old_refresh_token",
+			Scope:        Scopes{ScopeCreate, ScopeUpdate},
+		},
+		client: ts.Client(),
+	}
+
+	token, err := c.Refresh("create")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	wantParams := url.Values{
+		"grant_type":    []string{"refresh_token"},
+		"refresh_token": []string{"old_refresh_token"},
+		"client_id":     []string{"http://localhost:9002/"},
+		"scope":         []string{"create"},
+	}
+	if !reflect.DeepEqual(got, wantParams) {
+		t.Errorf("Refresh() sent %v, want %v", got, wantParams)
+	}
+	if token != "new_access_token" {
+		t.Errorf("Expected access token 'new_access_token', got '%v'", token)
+	}
+
+	want := Token{
+		AccessToken:  "new_access_token",
+		Expires:      3600,
+		Expiry:       now.Add(time.Hour),
+		RefreshToken: "new_refresh_token",
+		Scope:        Scopes{ScopeCreate},
+	}
+	if !reflect.DeepEqual(c.Token, want) {
+		t.Errorf("Refresh() token = %v, want %v", c.Token, want)
+	}
+
+	me = "https://example.com"
+	if _, err := c.Refresh(); err != nil {
+		t.Errorf("Expected me to match once normalized, got %v", err)
+	}
+
+	scope = ""
+	c.Token.Scope = Scopes{ScopeCreate, ScopeUpdate, ScopeDelete}
+	if _, err := c.Refresh(ScopeUpdate); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(c.Token.Scope, Scopes{ScopeUpdate}) {
+		t.Errorf("Expected the narrowed scopes to be granted when the response omits them, got %v", c.Token.Scope)
+	}
+	if _, err := c.Refresh(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(c.Token.Scope, Scopes{ScopeUpdate}) {
+		t.Errorf("Expected the previous scopes to be kept when none were requested, got %v", c.Token.Scope)
+	}
+
+	c.Identifier.ProfileURL = "https://other.example.com/"
+	if _, err := c.Refresh(); err == nil {
+		t.Errorf("Expected an error when the refreshed token is for a different user")
+	}
+
+	c.Token.RefreshToken = ""
+	if _, err := c.Refresh(); err == nil {
+		t.Errorf("Expected an error without a refresh token")
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		token       Token
+		expired     bool
+		expiresSoon bool
+	}{
+		{"No expiry", Token{}, false, false},
+		{"Expired", Token{Expiry: now.Add(-time.Minute)}, true, true},
+		{"Expires soon", Token{Expiry: now.Add(30 * time.Second)}, false, true},
+		{"Expires later", Token{Expiry: now.Add(time.Hour)}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.token.IsExpired(); got != tt.expired {
+				t.Errorf("IsExpired() = %v, want %v", got, tt.expired)
+			}
+			if got := tt.token.ExpiresWithin(time.Minute); got != tt.expiresSoon {
+				t.Errorf("ExpiresWithin() = %v, want %v", got, tt.expiresSoon)
+			}
+		})
+	}
+}
+
+func TestTokenSource(t *testing.T) {
+	refreshes := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refreshes++
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TokenResponseParams{
+			AccessToken: "new_access_token",
+			Expires:     3600,
+		})
+	}))
+	defer ts.Close()
+
+	c := Config{
+		Endpoint: Endpoint{TokenURL: ts.URL},
+		Token: Token{
+			AccessToken:  "old_access_token",
+			Expiry:       time.Now().Add(time.Hour),
+			RefreshToken: "refresh_token",
+		},
+		client: ts.Client(),
+	}
+	source := c.TokenSource(context.Background())
+
+	token, err := source.Token()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if token.AccessToken != "old_access_token" || refreshes != 0 {
+		t.Errorf("Expected the unexpired token to be reused, got '%v' after %v refreshes", token.AccessToken, refreshes)
+	}
+
+	c.Token.Expiry = time.Now().Add(time.Second)
+	token, err = source.Token()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if token.AccessToken != "new_access_token" || refreshes != 1 {
+		t.Errorf("Expected the token to be refreshed, got '%v' after %v refreshes", token.AccessToken, refreshes)
+	}
+	if token.RefreshToken != "refresh_token" {
+		t.Errorf("Expected the refresh token to be kept, got '%v'", token.RefreshToken)
+	}
+
+	c.Token.Expiry = time.Now().Add(-time.Second)
+	c.Token.RefreshToken = ""
+	if _, err := source.Token(); err == nil {
+		t.Errorf("Expected an error for an expired token without a refresh token")
+	}
+}
@@ -0,0 +1,127 @@
+package indieAuth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Transport is an http.RoundTripper that authorizes requests with the access token from Source. When the
+// resource server rejects the token as invalid, the token is refreshed and the request retried once.
+type Transport struct {
+	Source TokenSource
+	// Base is the RoundTripper used to make requests. If nil, http.DefaultTransport is used.
+	Base http.RoundTripper
+}
+
+// Client returns an http.Client that attaches c.Token to every request, refreshing it as needed. This is how
+// Micropub and Microsub requests should be made on behalf of the user.
+func (c *Config) Client(ctx context.Context) *http.Client {
+	client := c.httpClient()
+
+	return &http.Client{
+		Transport: &Transport{
+			Source: c.TokenSource(ctx),
+			Base:   client.Transport,
+		},
+		CheckRedirect: client.CheckRedirect,
+		Jar:           client.Jar,
+		Timeout:       client.Timeout,
+	}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+
+	if t.Source == nil {
+		return nil, errors.New("indieAuth: Transport's Source is nil")
+	}
+
+	token, err := t.Source.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base().RoundTrip(authorizedRequest(req, token, req.Body))
+	if err != nil || !isInvalidToken(resp) {
+		return resp, err
+	}
+
+	// Only requests whose body can be replayed are retried.
+	refresher, ok := t.Source.(tokenRefresher)
+	if !ok || (req.Body != nil && req.GetBody == nil) {
+		return resp, nil
+	}
+
+	refreshed, err := refresher.refreshToken(token.AccessToken)
+	if err != nil {
+		return resp, nil
+	}
+
+	body := req.Body
+	if req.GetBody != nil {
+		if body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+
+	return t.base().RoundTrip(authorizedRequest(req, refreshed, body))
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// authorizedRequest clones req, as a RoundTripper must not modify it, and sets the bearer token.
+func authorizedRequest(req *http.Request, token Token, body io.ReadCloser) *http.Request {
+	r := req.Clone(req.Context())
+	r.Body = body
+	r.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+	return r
+}
+
+// isInvalidToken reports whether the resource server rejected the access token.
+// https://www.rfc-editor.org/rfc/rfc6750#section-3.1
+func isInvalidToken(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+
+	for _, challenge := range resp.Header.Values("WWW-Authenticate") {
+		if strings.Contains(challenge, `error="invalid_token"`) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tokenRefresher is implemented by token sources that can refresh a token before it expires.
+type tokenRefresher interface {
+	// refreshToken refreshes the token unless it has already been replaced since failed was issued.
+	refreshToken(failed string) (Token, error)
+}
+
+func (s *refreshingTokenSource) refreshToken(failed string) (Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.c.Token.AccessToken != failed {
+		return s.c.Token, nil
+	}
+
+	if _, err := s.c.RefreshContext(s.ctx); err != nil {
+		return Token{}, err
+	}
+
+	return s.c.Token, nil
+}
@@ -0,0 +1,81 @@
+package indieAuth
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TokenResponseParams{
+			AccessToken: "new_access_token",
+			Expires:     3600,
+		})
+	}))
+	defer tokenServer.Close()
+
+	var requests []string
+	micropub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r.Header.Get("Authorization")+" "+string(body))
+		if r.Header.Get("Authorization") != "Bearer new_access_token" {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer micropub.Close()
+
+	c := Config{
+		Endpoint: Endpoint{TokenURL: tokenServer.URL},
+		Token: Token{
+			AccessToken:  "revoked_access_token",
+			Expiry:       time.Now().Add(time.Hour),
+			RefreshToken: "refresh_token",
+		},
+		client: tokenServer.Client(),
+	}
+
+	resp, err := c.Client(context.Background()).Post(micropub.URL, "application/x-www-form-urlencoded", strings.NewReader("h=entry"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("Expected status %v, got %v", http.StatusCreated, resp.StatusCode)
+	}
+
+	want := []string{"Bearer revoked_access_token h=entry", "Bearer new_access_token h=entry"}
+	if strings.Join(requests, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected requests %q, got %q", want, requests)
+	}
+}
+
+func TestTransportWithoutRefreshToken(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	c := Config{Token: Token{AccessToken: "revoked_access_token"}, client: ts.Client()}
+
+	resp, err := c.Client(context.Background()).Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status %v, got %v", http.StatusUnauthorized, resp.StatusCode)
+	}
+}